@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// actionSpecs is the ACTIONS parameter, either a comma separated list of
+// action names or a JSON list whose entries are action names or objects
+// with a condition:
+//
+//	[{"action": "deploy", "when": {"branch": "main", "event": "push"}}]
+type actionSpecs []actionSpec
+
+// actionSpec is an action with an optional condition.
+type actionSpec struct {
+	Action string     `json:"action"`
+	When   *condition `json:"when"`
+}
+
+// condition restricts an action to builds of matching branches (glob
+// patterns), events and tags.
+type condition struct {
+	Branch stringList `json:"branch"`
+	Event  stringList `json:"event"`
+	Tag    *bool      `json:"tag"`
+}
+
+// stringList accepts a single string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+func (a *actionSpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		a.Action = name
+		return nil
+	}
+	type plain actionSpec
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// Decode implements envconfig.Decoder.
+func (s *actionSpecs) Decode(value string) error {
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		return json.Unmarshal([]byte(value), (*[]actionSpec)(s))
+	}
+	*s = nil
+	for _, name := range strings.Split(value, ",") {
+		*s = append(*s, actionSpec{Action: name})
+	}
+	return nil
+}
+
+// extraArgs is the HELM_EXTRA_ARGS parameter, a JSON object mapping action
+// names to an argument or a list of arguments:
+//
+//	{"deploy": ["--force", "--description=drone"], "lint": "--strict"}
+type extraArgs map[string]stringList
+
+// Decode implements envconfig.Decoder.
+func (e *extraArgs) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*map[string]stringList)(e))
+}
+
+// enabled returns the names of the actions whose conditions match b and
+// the names of the skipped ones.
+func (s actionSpecs) enabled(b Build) (enabled, skipped []string) {
+	for _, a := range s {
+		if a.When.matches(b) {
+			enabled = append(enabled, a.Action)
+		} else {
+			skipped = append(skipped, a.Action)
+		}
+	}
+	return enabled, skipped
+}
+
+// matches reports whether the build satisfies the condition.
+func (c *condition) matches(b Build) bool {
+	if c == nil {
+		return true
+	}
+	if len(c.Branch) > 0 && !matchAny(c.Branch, b.Branch) {
+		return false
+	}
+	if len(c.Event) > 0 && !matchAny(c.Event, b.Event) {
+		return false
+	}
+	if c.Tag != nil && *c.Tag != (b.Tag != "") {
+		return false
+	}
+	return true
+}
+
+// matchAny reports whether s matches any of the glob patterns.
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
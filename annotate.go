@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// tillerNamespace is where Tiller stores the release configmaps.
+const tillerNamespace = "kube-system"
+
+// releaseAnnotations returns the annotations describing who shipped the
+// release, derived from the Drone build.
+func (p Plugin) releaseAnnotations() map[string]string {
+	annotations := map[string]string{
+		"drone-gcloud-helm/author":     p.Build.Author,
+		"drone-gcloud-helm/commit":     p.Build.Commit,
+		"drone-gcloud-helm/build-link": p.Build.Link,
+	}
+	if p.Build.PullRequest != "" {
+		annotations["drone-gcloud-helm/pull-request"] = p.Build.PullRequest
+	}
+	return annotations
+}
+
+// annotateRelease annotates the storage object of the current release
+// revision with the build metadata: the Tiller configmap on Helm 2, the
+// release secret in the namespace of the release on Helm 3.
+// kubectl annotate --overwrite configmap $RELEASE.v$REVISION key=value...
+// kubectl annotate --overwrite secret sh.helm.release.v1.$RELEASE.v$REVISION key=value...
+func (p Plugin) annotateRelease() error {
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return err
+	}
+	rev, err := p.releaseRevision()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"annotate", "--overwrite",
+		"--namespace", tillerNamespace,
+		fmt.Sprintf("configmap/%s.v%s", p.Release, rev),
+	}
+	if major == 3 {
+		args = []string{"annotate", "--overwrite",
+			"--namespace", p.Namespace,
+			fmt.Sprintf("secret/sh.helm.release.v1.%s.v%s", p.Release, rev),
+		}
+	}
+	for k, v := range p.releaseAnnotations() {
+		args = append(args, fmt.Sprintf("%s=%s", k, v))
+	}
+	cmd := exec.Command(p.kubectl(), args...)
+	return p.run(cmd)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// reportRollback logs the revision a failed atomic upgrade rolled the
+// release back to. A failed first install is deleted instead, leaving no
+// history.
+// helm history $RELEASE --max 1
+func (p Plugin) reportRollback() {
+	log := logrus.WithField("release", p.Release)
+	rev, err := p.releaseRevision()
+	if err != nil {
+		log.WithError(err).Warn("upgrade failed, no release revision left, the failed install was deleted")
+		return
+	}
+	log.WithField("revision", rev).Warn("upgrade failed, release rolled back")
+}
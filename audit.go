@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// auditPath returns the object the manifests of the release revision are
+// exported to.
+func (p Plugin) auditPath(revision string) string {
+	path := strings.TrimSuffix(p.AuditBucket, "/")
+	if !strings.HasPrefix(path, "gs://") {
+		path = "gs://" + path
+	}
+	cluster := p.Cluster
+	if cluster == "" {
+		cluster = "in-cluster"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s.yaml", path, cluster, p.Namespace, p.Release, revision)
+}
+
+// exportManifests uploads the manifests of the deployed release revision
+// to AuditBucket, so they can be reviewed without cluster access.
+// helm get manifest $RELEASE
+// gsutil cp manifest.yaml gs://$PLUGIN_AUDIT_BUCKET/$CLUSTER/$NAMESPACE/$RELEASE/$REVISION.yaml
+func (p Plugin) exportManifests() error {
+	revision, err := p.releaseRevision()
+	if err != nil {
+		return err
+	}
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(p.helm(), append([]string{"get", "manifest", p.Release}, namespace...)...)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "manifest")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(out.Bytes())
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	dest := p.auditPath(revision)
+	cmd = exec.Command(gsutilBin,
+		"-h", "x-goog-meta-commit:"+p.Build.Commit,
+		"-h", "x-goog-meta-build-link:"+p.Build.Link,
+		"-h", "Content-Type:application/yaml",
+		"cp", f.Name(), dest,
+	)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	logrus.WithField("object", dest).Info("exported manifests")
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// auth modes, see AuthMode
+const (
+	authModeKey              = "key"
+	authModeADC              = "adc"
+	authModeWorkloadIdentity = "workload-identity"
+)
+
+// authCache remembers the authentication and cluster setup steps done by
+// the run, so multiple actions, targets and a retried execution don't
+// repeat them.
+type authCache struct {
+	mu   sync.Mutex
+	done map[string]bool
+}
+
+// once calls f unless it succeeded for key before. A nil cache always calls
+// f.
+func (c *authCache) once(key string, f func() error) error {
+	if c == nil {
+		return f()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done[key] {
+		return nil
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	if c.done == nil {
+		c.done = make(map[string]bool)
+	}
+	c.done[key] = true
+	return nil
+}
+
+// validateAuthMode checks AuthMode.
+func (p Plugin) validateAuthMode() error {
+	switch p.AuthMode {
+	case authModeKey, authModeADC, authModeWorkloadIdentity:
+		return nil
+	}
+	return fmt.Errorf("unknown auth_mode %q, use %s, %s or %s",
+		p.AuthMode, authModeKey, authModeADC, authModeWorkloadIdentity)
+}
+
+// authenticated reports whether gcloud can be authenticated, with the auth
+// key or the ambient credentials of the auth mode.
+func (p Plugin) authenticated() bool {
+	return p.AuthKey != "" || p.AuthMode != authModeKey
+}
+
+// useAmbientCredentials makes gcloud use the Application Default
+// Credentials instead of activating a key. In adc mode a credentials file
+// of GOOGLE_APPLICATION_CREDENTIALS is used by gcloud, otherwise, as with
+// Workload Identity, the account of the metadata server.
+// gcloud config set auth/credential_file_override $GOOGLE_APPLICATION_CREDENTIALS
+func (p Plugin) useAmbientCredentials() error {
+	if err := p.useGcloudConfig(); err != nil {
+		return err
+	}
+	file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if p.AuthMode == authModeADC && file != "" {
+		cmd := exec.Command(gcloudBin, "config", "set", "auth/credential_file_override", file)
+		if err := p.run(cmd); err != nil {
+			return err
+		}
+	}
+	logrus.WithField("auth_mode", p.AuthMode).Info("using application default credentials")
+	return nil
+}
+
+// impersonateAccount makes the gcloud configuration of the run impersonate
+// ImpersonateServiceAccount, so the cluster credentials kubectl and helm
+// get from gcloud are the ones of the impersonated account as well. The
+// gcloud and gsutil commands of the plugin pass it explicitly, see
+// addExtraArgs.
+// gcloud config set auth/impersonate_service_account $PLUGIN_IMPERSONATE_SERVICE_ACCOUNT
+func (p Plugin) impersonateAccount() error {
+	if p.ImpersonateServiceAccount == "" {
+		return nil
+	}
+	cmd := exec.Command(gcloudBin, "config", "set",
+		"auth/impersonate_service_account", p.ImpersonateServiceAccount)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	logrus.WithField("account", p.ImpersonateServiceAccount).Info("impersonating service account")
+	return nil
+}
+
+// needsCluster reports whether any action needs cluster credentials.
+// Packaging and chart storage actions only need the service account.
+func (p Plugin) needsCluster() bool {
+	for _, a := range p.Actions {
+		if clusterActions[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterKey identifies the cluster credentials of p.
+func (p Plugin) clusterKey() string {
+	return p.Project + "/" + p.Zone + p.Region + "/" + p.Cluster + "/" + p.kubeconfigPath()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// serviceAccountKey is the part of a service account key checked before it
+// is activated.
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+}
+
+// resolveAuthKey sets AuthKey from AuthKeyBase64 or AuthKeyPath and decodes
+// a base64 encoded AuthKey, then validates the key, so a broken secret
+// fails early with a clear message instead of a gcloud error.
+func (p *Plugin) resolveAuthKey() error {
+	given := 0
+	for _, v := range []string{p.AuthKey, p.AuthKeyBase64, p.AuthKeyPath} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return errors.New("set only one of auth_key, auth_key_base64 and auth_key_path")
+	}
+
+	source := "auth_key"
+	switch {
+	case p.AuthKeyBase64 != "":
+		source = "auth_key_base64"
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(p.AuthKeyBase64))
+		if err != nil {
+			return fmt.Errorf("auth_key_base64 is not base64 encoded: %s", err)
+		}
+		p.AuthKey = string(key)
+	case p.AuthKeyPath != "":
+		source = "auth_key_path"
+		key, err := ioutil.ReadFile(p.AuthKeyPath)
+		if err != nil {
+			return fmt.Errorf("auth_key_path: %s", err)
+		}
+		p.AuthKey = string(key)
+	case p.AuthKey == "":
+		return nil
+	}
+
+	key := strings.TrimSpace(p.AuthKey)
+	// some secret stores only take single line values
+	if !strings.HasPrefix(key, "{") {
+		if decoded, err := base64.StdEncoding.DecodeString(key); err == nil {
+			key = string(decoded)
+		}
+	}
+	var sa serviceAccountKey
+	if err := json.Unmarshal([]byte(key), &sa); err != nil {
+		return fmt.Errorf("%s is no JSON service account key: %s", source, err)
+	}
+	if sa.Type != "" && sa.Type != "service_account" {
+		return fmt.Errorf("%s is a %s key, not a service account key", source, sa.Type)
+	}
+	if sa.ProjectID == "" || sa.ClientEmail == "" {
+		return fmt.Errorf("%s has no project_id or client_email, is it a service account key?", source)
+	}
+	// resolved once, preparePlugin may run again
+	p.AuthKey, p.AuthKeyBase64, p.AuthKeyPath = key, "", ""
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	gcsObjectURL  = "https://storage.googleapis.com/storage/v1/b/%s/o/%s"
+	kmsDecryptURL = "https://cloudkms.googleapis.com/v1/%s:decrypt"
+
+	// berglasKMSKeyMetadata is the object metadata holding the KMS key
+	berglasKMSKeyMetadata = "berglas-kms-key"
+)
+
+// berglasSecret reads and decrypts a Berglas secret stored in object of
+// bucket. The object holds the KMS encrypted data encryption key and the
+// AES-GCM encrypted secret, both base64 encoded and separated by a colon.
+func (p Plugin) berglasSecret(bucket, object string) (string, error) {
+	token, err := p.accessToken()
+	if err != nil {
+		return "", err
+	}
+	auth := map[string]string{"Authorization": "Bearer " + token}
+
+	var attrs struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := sendJSON("GET", fmt.Sprintf(gcsObjectURL, bucket, url.PathEscape(object)), auth, nil, &attrs); err != nil {
+		return "", err
+	}
+	key := attrs.Metadata[berglasKMSKeyMetadata]
+	if key == "" {
+		return "", fmt.Errorf("gs://%s/%s is no berglas secret", bucket, object)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(gsutilBin, "cat", fmt.Sprintf("gs://%s/%s", bucket, object))
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(strings.TrimSpace(out.String()), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("gs://%s/%s has an invalid berglas format", bucket, object)
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"ciphertext":                  parts[0],
+		"additionalAuthenticatedData": base64.StdEncoding.EncodeToString([]byte(object)),
+	})
+	if err != nil {
+		return "", err
+	}
+	var decrypted struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := sendJSON("POST", fmt.Sprintf(kmsDecryptURL, key), auth, body, &decrypted); err != nil {
+		return "", err
+	}
+	dek, err := base64.StdEncoding.DecodeString(decrypted.Plaintext)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("gs://%s/%s has an invalid berglas format", bucket, object)
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// resolveEnvSecrets resolves the secret references of the env-file entries
+// and exports the resolved values, so they can be used in values.
+func (p Plugin) resolveEnvSecrets() error {
+	if p.EnvFile == "" {
+		return nil
+	}
+	env, err := godotenv.Read(p.EnvFile)
+	if err != nil {
+		return err
+	}
+	for k, v := range env {
+		// the process environment wins over the env-file, see godotenv.Load
+		if current := os.Getenv(k); current != v || !isSecretRef(v) {
+			continue
+		}
+		value, err := p.resolveSecretRef(v)
+		if err != nil {
+			return err
+		}
+		p.mask.add(value)
+		if err := os.Setenv(k, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
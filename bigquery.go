@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const bigqueryInsertURL = "https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll"
+
+// exportBigquery streams a row per executed action into the configured
+// BigQuery table using the active service account.
+func (p Plugin) exportBigquery() error {
+	results := p.report.results()
+	if len(results) == 0 {
+		return nil
+	}
+	project := p.BigqueryProject
+	if project == "" {
+		project = p.Project
+	}
+
+	rows := make([]interface{}, 0, len(results))
+	for _, r := range results {
+		rows = append(rows, map[string]interface{}{
+			"insertId": fmt.Sprintf("%s-%s-%s-%d", p.Build.Repo, p.Build.Number, r.Action, r.Started.UnixNano()),
+			"json": map[string]interface{}{
+				"action":           r.Action,
+				"status":           r.Status,
+				"error":            r.Error,
+				"started":          r.Started,
+				"duration_seconds": r.Duration,
+				"project":          p.Project,
+				"cluster":          p.Cluster,
+				"namespace":        p.Namespace,
+				"release":          p.Release,
+				"chart":            p.Package,
+				"chart_version":    p.ChartVersion,
+				"repo":             p.Build.Repo,
+				"build_number":     p.Build.Number,
+				"build_link":       p.Build.Link,
+				"commit":           p.Build.Commit,
+				"author":           p.Build.Author,
+			},
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{"rows": rows})
+	if err != nil {
+		return err
+	}
+
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		InsertErrors []struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	if err := sendJSON("POST", fmt.Sprintf(bigqueryInsertURL, project, p.BigqueryDataset, p.BigqueryTable),
+		map[string]string{"Authorization": "Bearer " + token}, body, &resp); err != nil {
+		return err
+	}
+	for _, e := range resp.InsertErrors {
+		if len(e.Errors) > 0 {
+			return fmt.Errorf("bigquery rejected row: %s", e.Errors[0].Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// chartLock is the part of requirements.lock (Helm 2) or Chart.lock
+// (Helm 3) we care about.
+type chartLock struct {
+	Dependencies []struct {
+		Name    string `yaml:"name"`
+		Version string `yaml:"version"`
+	} `yaml:"dependencies"`
+}
+
+// lockedDependencies returns the archive names of the locked dependencies
+// of the chart. ok is false when the chart has no lock file.
+func (p Plugin) lockedDependencies() (archives []string, ok bool, err error) {
+	for _, name := range []string{"requirements.lock", "Chart.lock"} {
+		data, err := ioutil.ReadFile(filepath.Join(p.ChartPath, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var lock chartLock
+		if err := yaml.Unmarshal(data, &lock); err != nil {
+			return nil, false, err
+		}
+		for _, d := range lock.Dependencies {
+			archives = append(archives, fmt.Sprintf("%s-%s.tgz", d.Name, d.Version))
+		}
+		return archives, true, nil
+	}
+	return nil, false, nil
+}
+
+// hasDependencies reports whether the chart declares dependencies in
+// requirements.yaml or has a lock file.
+func (p Plugin) hasDependencies() bool {
+	for _, name := range []string{"requirements.yaml", "requirements.lock", "Chart.lock"} {
+		if _, err := os.Stat(filepath.Join(p.ChartPath, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// buildDependencies fetches the dependencies of the chart into its charts
+// directory. When all locked dependencies are in CacheDir they are copied
+// from there, skipping the download and the repository update of helm.
+// Fetched dependencies are added to the cache.
+// helm dependency build $PLUGIN_CHART_PATH
+func (p Plugin) buildDependencies() error {
+	if !p.hasDependencies() {
+		return nil
+	}
+	cache := filepath.Join(p.CacheDir, "charts")
+	charts := filepath.Join(p.ChartPath, "charts")
+
+	archives, locked, err := p.lockedDependencies()
+	if err != nil {
+		return err
+	}
+	if locked && cached(cache, archives) {
+		if err := os.MkdirAll(charts, 0755); err != nil {
+			return err
+		}
+		for _, a := range archives {
+			if err := cp(filepath.Join(cache, a), filepath.Join(charts, a)); err != nil {
+				return err
+			}
+		}
+		logrus.WithField("charts", len(archives)).Info("using cached dependencies")
+		return nil
+	}
+
+	cmd := exec.Command(p.helm(), "dependency", "build", p.ChartPath)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		return err
+	}
+	fetched, err := filepath.Glob(filepath.Join(charts, "*.tgz"))
+	if err != nil {
+		return err
+	}
+	for _, f := range fetched {
+		if err := cp(f, filepath.Join(cache, filepath.Base(f))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cached reports whether all archives exist in dir.
+func cached(dir string, archives []string) bool {
+	for _, a := range archives {
+		if _, err := os.Stat(filepath.Join(dir, a)); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// changedFiles returns the files changed between the commit before the
+// build and the commit of the build, relative to the working directory.
+// ok is false when the build has no previous commit, e.g. the first push
+// of a branch.
+// git diff --name-only --relative $DRONE_COMMIT_BEFORE $DRONE_COMMIT_SHA
+func (p Plugin) changedFiles() (files []string, ok bool, err error) {
+	before := p.Build.Before
+	if before == "" || strings.Trim(before, "0") == "" || p.Build.Commit == "" {
+		return nil, false, nil
+	}
+	var out bytes.Buffer
+	cmd := exec.Command("git", "diff", "--name-only", "--relative", before, p.Build.Commit)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return nil, false, err
+	}
+	for _, f := range strings.Split(out.String(), "\n") {
+		if f = strings.TrimSpace(f); f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, true, nil
+}
+
+// chartChanged reports whether one of files is in the chart directory dir.
+func chartChanged(dir string, files []string) bool {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if dir == "." {
+		return len(files) > 0
+	}
+	for _, f := range files {
+		if f == dir || strings.HasPrefix(f, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// selectChangedCharts keeps the charts with changed files when ChangedOnly
+// is set. Without a changed chart no action is executed. When the changes
+// can't be determined all charts are kept.
+func (p *Plugin) selectChangedCharts() {
+	if !p.ChangedOnly || p.ChartPath == "" && len(p.Charts) == 0 {
+		return
+	}
+	files, ok, err := p.changedFiles()
+	if err != nil {
+		logrus.WithError(err).Warn("failed to detect changed charts, executing all charts")
+		return
+	}
+	if !ok {
+		logrus.Info("no previous commit to detect changed charts, executing all charts")
+		return
+	}
+
+	if len(p.Charts) == 0 {
+		if !chartChanged(p.ChartPath, files) {
+			logrus.WithField("chart_path", p.ChartPath).Info("chart not changed, skipping actions")
+			p.Actions = nil
+		}
+		return
+	}
+
+	kept := make(map[string]bool)
+	var changed charts
+	for _, ch := range p.Charts {
+		if chartChanged(ch.ChartPath, files) {
+			changed = append(changed, ch)
+			kept[ch.Name] = true
+			continue
+		}
+		logrus.WithField("chart", ch.Name).Info("chart not changed, skipping chart")
+	}
+	// the charts depended on may be left out, they are deployed already
+	for i := range changed {
+		var deps []string
+		for _, d := range changed[i].DependsOn {
+			if kept[d] {
+				deps = append(deps, d)
+			}
+		}
+		changed[i].DependsOn = deps
+	}
+	p.Charts = changed
+	if len(changed) == 0 {
+		logrus.Info("no chart changed, skipping actions")
+		p.Actions = nil
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// listsCharts reports whether ChartPath is a glob or a comma separated
+// list of charts rather than the path of a single chart.
+func (p Plugin) listsCharts() bool {
+	return strings.ContainsAny(p.ChartPath, ",*?[")
+}
+
+// expandChartPaths turns a ChartPath listing charts into Charts, one chart
+// for each matched directory with a Chart.yaml, named after the name of
+// its Chart.yaml. The package and release default to that name.
+func (p *Plugin) expandChartPaths() error {
+	if !p.listsCharts() {
+		return nil
+	}
+	if len(p.Charts) > 0 {
+		return fmt.Errorf("chart_path %s lists charts, it can't be used with charts", p.ChartPath)
+	}
+
+	var (
+		cs   charts
+		seen = make(map[string]bool)
+	)
+	for _, pattern := range strings.Split(p.ChartPath, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("chart_path %s: %s", pattern, err)
+		}
+		for _, dir := range matches {
+			dir = filepath.Clean(dir)
+			if seen[dir] {
+				continue
+			}
+			// globs like charts/* match other files and directories too
+			if _, err := os.Stat(filepath.Join(dir, "Chart.yaml")); err != nil {
+				continue
+			}
+			meta, err := readChartMetadata(dir)
+			if err != nil {
+				return err
+			}
+			if meta.Name == "" {
+				return fmt.Errorf("%s/Chart.yaml has no name", dir)
+			}
+			seen[dir] = true
+			cs = append(cs, chart{Name: meta.Name, ChartPath: dir, Package: meta.Name})
+		}
+	}
+	if len(cs) == 0 {
+		return fmt.Errorf("chart_path %s matches no charts", p.ChartPath)
+	}
+
+	names := make([]string, len(cs))
+	for i, ch := range cs {
+		names[i] = ch.Name
+	}
+	logrus.WithField("charts", strings.Join(names, ",")).Info("chart_path matched charts")
+	// preparePlugin may run again on retries
+	p.Charts = cs
+	p.ChartPath = ""
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// charts is the CHARTS parameter, a JSON list of charts the actions are
+// executed for, one chart after the other:
+//
+//	[{"name": "crds", "chart_path": "charts/crds"},
+//	 {"name": "operator", "chart_path": "charts/operator", "depends_on": ["crds"]},
+//	 {"name": "app", "chart_path": "charts/app", "depends_on": ["operator"],
+//	  "values": ["replicas=3"]}]
+//
+// A chart is handled after the charts it depends on. When a chart fails the
+// remaining charts are skipped.
+type charts []chart
+
+// chart is a chart of the CHARTS parameter. Package defaults to the last
+// element of the chart path, release to the package and the chart version
+// to the top-level one. The values and values files are applied on top of
+// the top-level and target ones.
+type chart struct {
+	Name         string   `json:"name"`
+	ChartPath    string   `json:"chart_path"`
+	Package      string   `json:"package"`
+	Release      string   `json:"release"`
+	ChartVersion string   `json:"chart_version"`
+	Values       []string `json:"values"`
+	ValuesFiles  []string `json:"values_files"`
+	DependsOn    []string `json:"depends_on"`
+}
+
+// Decode implements envconfig.Decoder.
+func (c *charts) Decode(value string) error {
+	if err := json.Unmarshal([]byte(value), (*[]chart)(c)); err != nil {
+		return err
+	}
+	for i := range *c {
+		ch := &(*c)[i]
+		if ch.ChartPath == "" {
+			return fmt.Errorf("chart %d: no chart_path", i)
+		}
+		if ch.Package == "" {
+			s := strings.Split(strings.TrimSuffix(ch.ChartPath, "/"), "/")
+			ch.Package = s[len(s)-1]
+		}
+		if ch.Name == "" {
+			ch.Name = ch.Package
+		}
+	}
+	return nil
+}
+
+// sorted returns the charts in dependency order. Charts without an order
+// between them keep the order they are declared in.
+func (c charts) sorted() (charts, error) {
+	index := make(map[string]int, len(c))
+	for i, ch := range c {
+		if _, ok := index[ch.Name]; ok {
+			return nil, fmt.Errorf("duplicate chart: %s", ch.Name)
+		}
+		index[ch.Name] = i
+	}
+	pending := make([]int, len(c))
+	dependents := make([][]int, len(c))
+	for i, ch := range c {
+		for _, d := range ch.DependsOn {
+			j, ok := index[d]
+			if !ok {
+				return nil, fmt.Errorf("chart %s depends on unknown chart %s", ch.Name, d)
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var (
+		order []chart
+		done  = make([]bool, len(c))
+	)
+	for len(order) < len(c) {
+		next := -1
+		for i := range c {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for i, ch := range c {
+				if !done[i] {
+					cycle = append(cycle, ch.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between charts: %s", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		order = append(order, c[next])
+		for _, i := range dependents[next] {
+			pending[i]--
+		}
+	}
+	return order, nil
+}
+
+// dependsOn reports whether the chart named name depends on the chart
+// named on, directly or through other charts.
+func (c charts) dependsOn(name, on string) bool {
+	for _, ch := range c {
+		if ch.Name != name {
+			continue
+		}
+		for _, d := range ch.DependsOn {
+			if d == on || c.dependsOn(d, on) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withChart returns a copy of p for chart ch.
+func (p Plugin) withChart(ch chart) Plugin {
+	c := p
+	c.Charts = nil
+	c.ChartPath = ch.ChartPath
+	c.Package = ch.Package
+	c.Release = ch.Release
+	if c.Release == "" {
+		c.Release = ch.Package
+	}
+	if ch.ChartVersion != "" {
+		c.ChartVersion = ch.ChartVersion
+	}
+	c.Values = append([]string{}, p.Values...)
+	for _, v := range ch.Values {
+		c.Values = append(c.Values, expandEnv(v))
+	}
+	c.ValuesFiles = append([]string{}, p.ValuesFiles...)
+	for _, f := range ch.ValuesFiles {
+		c.ValuesFiles = append(c.ValuesFiles, expandEnv(f))
+	}
+	if c.target != "" {
+		c.target += ":" + ch.Name
+	} else {
+		c.target = ch.Name
+	}
+	return c
+}
+
+// execCharts executes the actions for each chart in dependency order,
+// the cluster actions on each of the targets ts if any. The charts after a
+// failed chart are skipped.
+func (p Plugin) execCharts(ts []Plugin) error {
+	order, err := p.Charts.sorted()
+	if err != nil {
+		return err
+	}
+	for i, ch := range order {
+		c := p.withChart(ch)
+		cts := make([]Plugin, len(ts))
+		for j, t := range ts {
+			cts[j] = t.withChart(ch)
+		}
+
+		logrus.WithField("chart", ch.Name).Info("executing chart")
+		err := c.execActions(cts)
+		if err == nil {
+			continue
+		}
+		logrus.WithError(err).WithField("chart", ch.Name).Error("chart failed")
+		for _, s := range order[i+1:] {
+			log := logrus.WithField("chart", s.Name)
+			if p.Charts.dependsOn(s.Name, ch.Name) {
+				log = log.WithField("failed_dependency", ch.Name)
+			}
+			log.Warn("skipping chart")
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ciSystem maps the environment of another CI system to the DRONE_* build
+// metadata and the PLUGIN_* parameters, so the image can run there as
+// well.
+type ciSystem struct {
+	name string
+	// detect is a variable only the CI system sets
+	detect string
+	// inputs is the prefix of step inputs, which are mapped to PLUGIN_*
+	inputs string
+	// arrays enables list inputs given as NAME_COUNT and NAME_0, NAME_1...
+	arrays bool
+	// build returns the DRONE_* variables
+	build func(env func(string) string) map[string]string
+}
+
+var ciSystems = []ciSystem{
+	{
+		name:   "GitHub Actions",
+		detect: "GITHUB_ACTIONS",
+		inputs: "INPUT_",
+		build:  githubBuild,
+	},
+	{
+		name:   "GitLab CI",
+		detect: "GITLAB_CI",
+		build:  gitlabBuild,
+	},
+	{
+		name:   "Bitbucket Pipelines",
+		detect: "BITBUCKET_BUILD_NUMBER",
+		arrays: true,
+		build:  bitbucketBuild,
+	},
+}
+
+// mapCIEnv sets the DRONE_* and PLUGIN_* variables of a detected CI
+// system which are not set already.
+func mapCIEnv() error {
+	for _, ci := range ciSystems {
+		if os.Getenv(ci.detect) == "" {
+			continue
+		}
+		logrus.WithField("ci", ci.name).Info("mapping CI environment")
+
+		vars := ci.build(os.Getenv)
+		if ci.inputs != "" {
+			for _, e := range os.Environ() {
+				kv := strings.SplitN(e, "=", 2)
+				if strings.HasPrefix(kv[0], ci.inputs) {
+					name := strings.TrimPrefix(kv[0], ci.inputs)
+					name = strings.NewReplacer("-", "_", " ", "_").Replace(strings.ToUpper(name))
+					vars["PLUGIN_"+name] = kv[1]
+				}
+			}
+		}
+		if ci.arrays {
+			for name := range listSettings(reflect.TypeOf(Plugin{})) {
+				name = strings.TrimPrefix(name, "PLUGIN_")
+				if list, ok := arrayInput(name); ok {
+					vars["PLUGIN_"+name] = list
+				}
+			}
+		}
+		for k, v := range vars {
+			if _, ok := os.LookupEnv(k); ok || v == "" {
+				continue
+			}
+			if err := os.Setenv(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// githubBuild maps the GitHub Actions default environment.
+func githubBuild(env func(string) string) map[string]string {
+	repoLink := env("GITHUB_SERVER_URL") + "/" + env("GITHUB_REPOSITORY")
+	vars := map[string]string{
+		"DRONE_REPO":          env("GITHUB_REPOSITORY"),
+		"DRONE_REPO_LINK":     repoLink,
+		"DRONE_BUILD_NUMBER":  env("GITHUB_RUN_NUMBER"),
+		"DRONE_BUILD_EVENT":   env("GITHUB_EVENT_NAME"),
+		"DRONE_BUILD_LINK":    repoLink + "/actions/runs/" + env("GITHUB_RUN_ID"),
+		"DRONE_COMMIT_SHA":    env("GITHUB_SHA"),
+		"DRONE_COMMIT_AUTHOR": env("GITHUB_ACTOR"),
+	}
+	switch {
+	case env("GITHUB_REF_TYPE") == "tag":
+		vars["DRONE_TAG"] = env("GITHUB_REF_NAME")
+		vars["DRONE_BUILD_EVENT"] = "tag"
+	case env("GITHUB_HEAD_REF") != "":
+		vars["DRONE_COMMIT_BRANCH"] = env("GITHUB_HEAD_REF")
+	default:
+		vars["DRONE_COMMIT_BRANCH"] = env("GITHUB_REF_NAME")
+	}
+	// refs/pull/<number>/merge
+	if ref := strings.Split(env("GITHUB_REF"), "/"); len(ref) == 4 && ref[1] == "pull" {
+		vars["DRONE_PULL_REQUEST"] = ref[2]
+	}
+	return vars
+}
+
+// gitlabBuild maps the GitLab CI predefined variables.
+func gitlabBuild(env func(string) string) map[string]string {
+	vars := map[string]string{
+		"DRONE_REPO":           env("CI_PROJECT_PATH"),
+		"DRONE_REPO_LINK":      env("CI_PROJECT_URL"),
+		"DRONE_BUILD_NUMBER":   env("CI_PIPELINE_IID"),
+		"DRONE_BUILD_EVENT":    env("CI_PIPELINE_SOURCE"),
+		"DRONE_BUILD_LINK":     env("CI_PIPELINE_URL"),
+		"DRONE_COMMIT_SHA":     env("CI_COMMIT_SHA"),
+		"DRONE_COMMIT_BEFORE":  env("CI_COMMIT_BEFORE_SHA"),
+		"DRONE_COMMIT_BRANCH":  env("CI_COMMIT_BRANCH"),
+		"DRONE_COMMIT_AUTHOR":  env("GITLAB_USER_LOGIN"),
+		"DRONE_COMMIT_MESSAGE": env("CI_COMMIT_MESSAGE"),
+		"DRONE_TAG":            env("CI_COMMIT_TAG"),
+		"DRONE_PULL_REQUEST":   env("CI_MERGE_REQUEST_IID"),
+		"DRONE_DEPLOY_TO":      env("CI_ENVIRONMENT_NAME"),
+	}
+	switch {
+	case env("CI_COMMIT_TAG") != "":
+		vars["DRONE_BUILD_EVENT"] = "tag"
+	case env("CI_MERGE_REQUEST_IID") != "":
+		vars["DRONE_BUILD_EVENT"] = "pull_request"
+		vars["DRONE_COMMIT_BRANCH"] = env("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME")
+	}
+	return vars
+}
+
+// bitbucketBuild maps the Bitbucket Pipelines default variables.
+func bitbucketBuild(env func(string) string) map[string]string {
+	repoLink := "https://bitbucket.org/" + env("BITBUCKET_REPO_FULL_NAME")
+	vars := map[string]string{
+		"DRONE_REPO":          env("BITBUCKET_REPO_FULL_NAME"),
+		"DRONE_REPO_LINK":     repoLink,
+		"DRONE_BUILD_NUMBER":  env("BITBUCKET_BUILD_NUMBER"),
+		"DRONE_BUILD_EVENT":   "push",
+		"DRONE_BUILD_LINK":    repoLink + "/addon/pipelines/home#!/results/" + env("BITBUCKET_BUILD_NUMBER"),
+		"DRONE_COMMIT_SHA":    env("BITBUCKET_COMMIT"),
+		"DRONE_COMMIT_BRANCH": env("BITBUCKET_BRANCH"),
+		"DRONE_TAG":           env("BITBUCKET_TAG"),
+		"DRONE_PULL_REQUEST":  env("BITBUCKET_PR_ID"),
+		"DRONE_DEPLOY_TO":     env("BITBUCKET_DEPLOYMENT_ENVIRONMENT"),
+	}
+	switch {
+	case env("BITBUCKET_TAG") != "":
+		vars["DRONE_BUILD_EVENT"] = "tag"
+	case env("BITBUCKET_PR_ID") != "":
+		vars["DRONE_BUILD_EVENT"] = "pull_request"
+	}
+	return vars
+}
+
+// arrayInput joins the list input given as NAME_COUNT and NAME_0, NAME_1...
+// with commas, the way Bitbucket pipes pass arrays.
+func arrayInput(name string) (string, bool) {
+	count, err := strconv.Atoi(os.Getenv(name + "_COUNT"))
+	if err != nil {
+		return "", false
+	}
+	entries := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		entries = append(entries, os.Getenv(fmt.Sprintf("%s_%d", name, i)))
+	}
+	return strings.Join(entries, ","), true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// previewNamespace is the part of a preview environment namespace we care
+// about.
+type previewNamespace struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+		Annotations       map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// previewNamespaces returns the namespaces labeled as preview environment.
+// kubectl get namespaces -l drone-gcloud-helm/preview=true -o json
+func (p Plugin) previewNamespaces() ([]previewNamespace, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(p.kubectl(), "get", "namespaces",
+		"-l", previewLabel+"=true",
+		"-o", "json",
+	)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []previewNamespace `json:"items"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// remoteBranches returns the branches of the origin remote.
+// git ls-remote --heads origin
+func (p Plugin) remoteBranches() (map[string]bool, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("git", "ls-remote", "--heads", "origin")
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return nil, err
+	}
+	branches := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			branches[strings.TrimPrefix(fields[1], "refs/heads/")] = true
+		}
+	}
+	return branches, nil
+}
+
+// cleanup destroys the preview environments which were not deployed for
+// CleanupTTL and, with CleanupBranches, those whose branch was deleted.
+func (p Plugin) cleanup() error {
+	namespaces, err := p.previewNamespaces()
+	if err != nil {
+		return err
+	}
+	var branches map[string]bool
+	if p.CleanupBranches {
+		if branches, err = p.remoteBranches(); err != nil {
+			return err
+		}
+	}
+
+	var removed []string
+	for _, ns := range namespaces {
+		deployed := ns.Metadata.CreationTimestamp
+		if t, err := time.Parse(time.RFC3339, ns.Metadata.Annotations["drone-gcloud-helm/deployed"]); err == nil {
+			deployed = t
+		}
+		branch := ns.Metadata.Annotations["drone-gcloud-helm/branch"]
+
+		var reason string
+		switch {
+		case p.CleanupTTL > 0 && time.Since(deployed) > p.CleanupTTL:
+			reason = "expired"
+		case branches != nil && branch != "" && !branches[branch]:
+			reason = "branch deleted"
+		default:
+			continue
+		}
+
+		c := p
+		c.Namespace = ns.Metadata.Name
+		c.Release = ns.Metadata.Annotations["drone-gcloud-helm/release"]
+		if c.Release == "" {
+			c.Release = c.Namespace
+		}
+		logrus.WithFields(logrus.Fields{
+			"namespace": c.Namespace,
+			"branch":    branch,
+			"deployed":  deployed.Format(time.RFC3339),
+			"reason":    reason,
+		}).Info("removing stale preview environment")
+		if err := c.destroyPreview(); err != nil {
+			return err
+		}
+		removed = append(removed, c.Namespace)
+	}
+	logrus.WithFields(logrus.Fields{
+		"removed": len(removed),
+		"kept":    len(namespaces) - len(removed),
+	}).Infof("preview environments cleaned up: %s", strings.Join(removed, ", "))
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const cloudLoggingURL = "https://logging.googleapis.com/v2/entries:write"
+
+// cloudLogHook collects the plugin log entries so they can be shipped to
+// Cloud Logging once the step is done.
+type cloudLogHook struct {
+	mu      sync.Mutex
+	entries []map[string]interface{}
+}
+
+func (h *cloudLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *cloudLogHook) Fire(e *logrus.Entry) error {
+	payload := map[string]interface{}{"message": e.Message}
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		payload[k] = v
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, map[string]interface{}{
+		"severity":    cloudLogSeverity(e.Level),
+		"timestamp":   e.Time.UTC().Format(time.RFC3339Nano),
+		"jsonPayload": payload,
+	})
+	return nil
+}
+
+// cloudLogSeverity maps logrus levels to Cloud Logging severities.
+func cloudLogSeverity(l logrus.Level) string {
+	switch l {
+	case logrus.DebugLevel:
+		return "DEBUG"
+	case logrus.InfoLevel:
+		return "INFO"
+	case logrus.WarnLevel:
+		return "WARNING"
+	case logrus.ErrorLevel:
+		return "ERROR"
+	default:
+		return "CRITICAL"
+	}
+}
+
+// shipLogs writes the collected log entries and a deploy summary entry to
+// Cloud Logging using the active service account.
+func (p Plugin) shipLogs(h *cloudLogHook, started time.Time, execErr error) error {
+	if p.Project == "" {
+		return fmt.Errorf("cloud logging requires a project")
+	}
+
+	summary := map[string]interface{}{
+		"message":  "deploy summary",
+		"release":  p.Release,
+		"chart":    p.Package,
+		"version":  p.ChartVersion,
+		"actions":  strings.Join(p.Actions, ","),
+		"duration": time.Since(started).Seconds(),
+		"status":   "success",
+	}
+	severity := "NOTICE"
+	if execErr != nil {
+		summary["status"] = "failure"
+		summary["error"] = execErr.Error()
+		severity = "ERROR"
+	}
+
+	h.mu.Lock()
+	entries := append(h.entries, map[string]interface{}{
+		"severity":    severity,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339Nano),
+		"jsonPayload": summary,
+	})
+	h.mu.Unlock()
+
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+
+	return postJSON(cloudLoggingURL, map[string]string{
+		"Authorization": "Bearer " + token,
+	}, map[string]interface{}{
+		"logName": fmt.Sprintf("projects/%s/logs/%s", p.Project, p.CloudLogName),
+		"resource": map[string]interface{}{
+			"type":   "global",
+			"labels": map[string]string{"project_id": p.Project},
+		},
+		"labels": map[string]string{
+			"project":   p.Project,
+			"cluster":   p.Cluster,
+			"namespace": p.Namespace,
+			"release":   p.Release,
+		},
+		"entries": entries,
+	})
+}
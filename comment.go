@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// diffComment renders the diff output as collapsed markdown, truncated to
+// limit bytes.
+func (p Plugin) diffComment(diff string, limit int) string {
+	truncated := ""
+	if limit > 0 && len(diff) > limit {
+		diff = diff[:limit]
+		truncated = "\n\n_Output truncated._"
+	}
+	if diff == "" {
+		diff = "No changes."
+	}
+	return fmt.Sprintf("#### helm diff of `%s` in `%s/%s`\n\n<details><summary>Show diff</summary>\n\n```diff\n%s\n```\n</details>%s",
+		p.Release, p.Cluster, p.Namespace, diff, truncated)
+}
+
+// commentPR posts body as comment on the pull request of the build using
+// the configured forge token.
+func (p Plugin) commentPR(body string) error {
+	pr := p.Build.PullRequest
+	switch {
+	case p.githubAPI() != "":
+		return postJSON(fmt.Sprintf("%s/repos/%s/issues/%s/comments", p.githubAPI(), p.Build.Repo, pr),
+			map[string]string{"Authorization": "token " + p.GithubToken},
+			map[string]string{"body": body})
+	case p.gitlabAPI() != "":
+		return postJSON(fmt.Sprintf("%s/projects/%s/merge_requests/%s/notes", p.gitlabAPI(), url.PathEscape(p.Build.Repo), pr),
+			map[string]string{"PRIVATE-TOKEN": p.GitlabToken},
+			map[string]string{"body": body})
+	case p.giteaAPI() != "":
+		return postJSON(fmt.Sprintf("%s/repos/%s/issues/%s/comments", p.giteaAPI(), p.Build.Repo, pr),
+			map[string]string{"Authorization": "token " + p.GiteaToken},
+			map[string]string{"body": body})
+	}
+	return fmt.Errorf("no forge token configured")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hoursPerMonth is the average number of hours of a month GCP bills.
+const hoursPerMonth = 730
+
+// costEstimate is the estimated monthly cost of the objects of a release.
+type costEstimate struct {
+	cpu, memory, storage, loadBalancers float64
+}
+
+func (c costEstimate) total() float64 {
+	return c.cpu + c.memory + c.storage + c.loadBalancers
+}
+
+// estimateCost estimates the monthly cost of the manifests from the
+// requests of the workloads, the PVC sizes and the LoadBalancer services.
+func (p Plugin) estimateCost(manifests string) (costEstimate, error) {
+	var c costEstimate
+	objects, err := parseManifests(manifests)
+	if err != nil {
+		return c, err
+	}
+	workloads, err := workloadRequests(objects)
+	if err != nil {
+		return c, err
+	}
+	for _, w := range workloads {
+		c.cpu += float64(w.replicas) * w.cpu * p.CostCPUHour * hoursPerMonth
+		c.memory += float64(w.replicas) * w.memory / (1 << 30) * p.CostMemoryGBHour * hoursPerMonth
+	}
+	for _, o := range objects {
+		var storage []string
+		replicas := 1
+		switch o.Kind {
+		case "PersistentVolumeClaim":
+			storage = append(storage, o.Spec.Resources.Requests["storage"])
+		case "StatefulSet":
+			if o.Spec.Replicas != nil {
+				replicas = *o.Spec.Replicas
+			}
+			for _, t := range o.Spec.VolumeClaimTemplates {
+				storage = append(storage, t.Spec.Resources.Requests["storage"])
+			}
+		case "Service":
+			if o.Spec.Type == "LoadBalancer" {
+				c.loadBalancers += p.CostLoadBalancerMonth
+			}
+		}
+		for _, s := range storage {
+			if s == "" {
+				continue
+			}
+			size, err := parseQuantity(s)
+			if err != nil {
+				return c, fmt.Errorf("%s/%s: %s", o.Kind, o.Metadata.Name, err)
+			}
+			c.storage += float64(replicas) * size / (1 << 30) * p.CostStorageGBMonth
+		}
+	}
+	return c, nil
+}
+
+// reportCost prints the estimated monthly cost of the release before and
+// after the deploy. It fails when the deploy increases the cost by more
+// than CostThreshold.
+// helm get manifest $RELEASE
+func (p Plugin) reportCost() error {
+	manifests, err := p.renderManifests()
+	if err != nil {
+		return err
+	}
+	estimate, err := p.estimateCost(manifests)
+	if err != nil {
+		return err
+	}
+
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return err
+	}
+	var previous costEstimate
+	var deployed bytes.Buffer
+	cmd := exec.Command(p.helm(), append([]string{"get", "manifest", p.Release}, namespace...)...)
+	cmd.Stdout = &deployed
+	if err := p.run(cmd); err != nil {
+		logrus.WithError(err).Info("no deployed release to compare the cost to, first deploy?")
+	} else if previous, err = p.estimateCost(deployed.String()); err != nil {
+		return err
+	}
+
+	fmt.Printf("estimated monthly cost of release %s:\n", p.Release)
+	fmt.Printf("  %-15s %10s %10s\n", "", "deployed", "new")
+	for _, row := range []struct {
+		name          string
+		previous, new float64
+	}{
+		{"cpu", previous.cpu, estimate.cpu},
+		{"memory", previous.memory, estimate.memory},
+		{"storage", previous.storage, estimate.storage},
+		{"load balancers", previous.loadBalancers, estimate.loadBalancers},
+		{"total", previous.total(), estimate.total()},
+	} {
+		fmt.Printf("  %-15s %10.2f %10.2f\n", row.name, row.previous, row.new)
+	}
+
+	increase := estimate.total() - previous.total()
+	logrus.WithFields(logrus.Fields{
+		"release":  p.Release,
+		"monthly":  fmt.Sprintf("%.2f", estimate.total()),
+		"increase": fmt.Sprintf("%.2f", increase),
+	}).Info("estimated cost")
+	if p.CostThreshold > 0 && increase > p.CostThreshold {
+		return fmt.Errorf("deploy increases the estimated monthly cost by %.2f, more than the cost_threshold of %.2f",
+			increase, p.CostThreshold)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// crdPollInterval is the interval in which CRDs are checked for the
+// Established condition.
+const crdPollInterval = 5 * time.Second
+
+// chartCRDs returns the documents of the crds directory of the chart
+// package and the names of the CRDs they define.
+func (p Plugin) chartCRDs() (docs []string, names []string, err error) {
+	if p.RemoteChart != "" {
+		return nil, nil, errors.New("install_crds needs a local package, it can't be used with remote_chart")
+	}
+	f, err := os.Open(fmt.Sprintf("%s-%s.tgz", p.Package, p.ChartVersion))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		// <chart>/crds/<file>, dependencies have their own crds directory
+		parts := strings.Split(h.Name, "/")
+		if len(parts) != 3 || parts[1] != "crds" {
+			continue
+		}
+		switch path.Ext(h.Name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, doc := range strings.Split(string(data), "\n---") {
+			var crd struct {
+				Kind     string `yaml:"kind"`
+				Metadata struct {
+					Name string `yaml:"name"`
+				} `yaml:"metadata"`
+			}
+			if err := yaml.Unmarshal([]byte(doc), &crd); err != nil {
+				return nil, nil, fmt.Errorf("%s: %s", h.Name, err)
+			}
+			if crd.Kind != "CustomResourceDefinition" {
+				continue
+			}
+			docs = append(docs, doc)
+			names = append(names, crd.Metadata.Name)
+		}
+	}
+	return docs, names, nil
+}
+
+// installCRDs applies the CRDs of the crds directory of the chart, which
+// Helm 2 ignores and Helm 3 never upgrades, and waits for them and the
+// WaitCRDs to be established before the chart is deployed.
+// kubectl apply -f -
+func (p Plugin) installCRDs() error {
+	names := append([]string{}, p.WaitCRDs...)
+	if p.InstallCRDs {
+		docs, crds, err := p.chartCRDs()
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			cmd := exec.Command(p.kubectl(), "apply", "-f", "-")
+			cmd.Stdin = strings.NewReader(strings.Join(docs, "\n---\n"))
+			if err := p.run(cmd); err != nil {
+				return err
+			}
+		}
+		names = append(names, crds...)
+	}
+	return p.waitForCRDs(names)
+}
+
+// waitForCRDs polls the CRDs until they have the Established condition or
+// WaitTimeout expired.
+// kubectl get crd $NAME -o json
+func (p Plugin) waitForCRDs(names []string) error {
+	stop := p.heartbeat("the crds of release " + p.Release)
+	defer stop()
+	deadline := time.Now().Add(time.Duration(p.WaitTimeout) * time.Second)
+	for _, name := range names {
+		for {
+			established, err := p.crdEstablished(name)
+			if err == nil && established {
+				logrus.WithField("crd", name).Info("crd established")
+				break
+			}
+			if time.Now().After(deadline) {
+				if err != nil {
+					return fmt.Errorf("crd %s not established: %s", name, err)
+				}
+				return fmt.Errorf("crd %s not established after %ds", name, p.WaitTimeout)
+			}
+			time.Sleep(crdPollInterval)
+		}
+	}
+	return nil
+}
+
+// crdEstablished reports whether the CRD has the Established condition.
+func (p Plugin) crdEstablished(name string) (bool, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(p.kubectl(), "get", "customresourcedefinition", name, "-o", "json")
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return false, err
+	}
+	var crd struct {
+		Status struct {
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &crd); err != nil {
+		return false, err
+	}
+	for _, c := range crd.Status.Conditions {
+		if c.Type == "Established" {
+			return c.Status == "True", nil
+		}
+	}
+	return false, nil
+}
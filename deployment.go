@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// deploymentTracker reports the state of a deploy to the forge hosting the
+// repository, so it shows up on the commit or pull request.
+type deploymentTracker interface {
+	// start creates the deployment and marks it in progress.
+	start() error
+	// finish marks the deployment as succeeded or failed.
+	finish(execErr error) error
+}
+
+// deploymentTracker returns the tracker for the forge of the repository or
+// nil when deployments should not be tracked.
+func (p Plugin) deploymentTracker() deploymentTracker {
+	if !p.hasAction(deployPkg) || p.Build.Commit == "" {
+		return nil
+	}
+	if api := p.githubAPI(); api != "" {
+		return &githubDeployment{p: p, api: api}
+	}
+	if api := p.gitlabAPI(); api != "" {
+		return &gitlabDeployment{p: p, api: api}
+	}
+	if api := p.giteaAPI(); api != "" {
+		return &giteaDeployment{p: p, api: api}
+	}
+	return nil
+}
+
+// githubAPI returns the GitHub API URL or an empty string when the
+// repository is not on GitHub or no token is configured.
+func (p Plugin) githubAPI() string {
+	if p.GithubToken == "" {
+		return ""
+	}
+	api := p.GithubAPI
+	if api == "" && repoHost(p.Build.RepoLink) == "github.com" {
+		api = "https://api.github.com"
+	}
+	return strings.TrimSuffix(api, "/")
+}
+
+// gitlabAPI returns the GitLab API URL or an empty string when no token is
+// configured.
+func (p Plugin) gitlabAPI() string {
+	if p.GitlabToken == "" {
+		return ""
+	}
+	api := p.GitlabAPI
+	if api == "" {
+		api = repoBaseURL(p.Build.RepoLink) + "/api/v4"
+	}
+	return strings.TrimSuffix(api, "/")
+}
+
+// giteaAPI returns the Gitea API URL or an empty string when no token is
+// configured.
+func (p Plugin) giteaAPI() string {
+	if p.GiteaToken == "" {
+		return ""
+	}
+	api := p.GiteaAPI
+	if api == "" {
+		api = repoBaseURL(p.Build.RepoLink) + "/api/v1"
+	}
+	return strings.TrimSuffix(api, "/")
+}
+
+// repoHost returns the host name of the repository link.
+func repoHost(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// repoBaseURL returns the scheme and host of the repository link.
+func repoBaseURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// githubDeployment tracks a deploy as GitHub deployment.
+type githubDeployment struct {
+	p   Plugin
+	api string
+	id  int64
+}
+
+func (g *githubDeployment) headers() map[string]string {
+	return map[string]string{
+		"Authorization": "token " + g.p.GithubToken,
+		"Accept":        "application/vnd.github+json",
+	}
+}
+
+func (g *githubDeployment) start() error {
+	body, err := json.Marshal(map[string]interface{}{
+		"ref":               g.p.Build.Commit,
+		"environment":       g.p.Environment,
+		"description":       fmt.Sprintf("%s %s", g.p.Package, g.p.ChartVersion),
+		"auto_merge":        false,
+		"required_contexts": []string{},
+	})
+	if err != nil {
+		return err
+	}
+	var deployment struct {
+		ID int64 `json:"id"`
+	}
+	if err := sendJSON("POST", fmt.Sprintf("%s/repos/%s/deployments", g.api, g.p.Build.Repo),
+		g.headers(), body, &deployment); err != nil {
+		return err
+	}
+	g.id = deployment.ID
+	return g.status("in_progress", "")
+}
+
+func (g *githubDeployment) finish(execErr error) error {
+	if g.id == 0 {
+		return nil
+	}
+	if execErr != nil {
+		return g.status("failure", execErr.Error())
+	}
+	return g.status("success", "")
+}
+
+// status creates a deployment status. GitHub limits the description to 140
+// characters.
+func (g *githubDeployment) status(state, description string) error {
+	if len(description) > 140 {
+		description = description[:140]
+	}
+	return postJSON(fmt.Sprintf("%s/repos/%s/deployments/%d/statuses", g.api, g.p.Build.Repo, g.id),
+		g.headers(), map[string]interface{}{
+			"state":       state,
+			"environment": g.p.Environment,
+			"log_url":     g.p.Build.Link,
+			"description": description,
+		})
+}
+
+// gitlabDeployment tracks a deploy as GitLab deployment of an environment.
+type gitlabDeployment struct {
+	p   Plugin
+	api string
+	id  int64
+}
+
+func (g *gitlabDeployment) url() string {
+	return fmt.Sprintf("%s/projects/%s/deployments", g.api, url.PathEscape(g.p.Build.Repo))
+}
+
+func (g *gitlabDeployment) headers() map[string]string {
+	return map[string]string{"PRIVATE-TOKEN": g.p.GitlabToken}
+}
+
+func (g *gitlabDeployment) start() error {
+	ref, tag := g.p.Build.Branch, false
+	if g.p.Build.Tag != "" {
+		ref, tag = g.p.Build.Tag, true
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"environment": g.p.Environment,
+		"sha":         g.p.Build.Commit,
+		"ref":         ref,
+		"tag":         tag,
+		"status":      "running",
+	})
+	if err != nil {
+		return err
+	}
+	var deployment struct {
+		ID int64 `json:"id"`
+	}
+	if err := sendJSON("POST", g.url(), g.headers(), body, &deployment); err != nil {
+		return err
+	}
+	g.id = deployment.ID
+	return nil
+}
+
+func (g *gitlabDeployment) finish(execErr error) error {
+	if g.id == 0 {
+		return nil
+	}
+	status := "success"
+	if execErr != nil {
+		status = "failed"
+	}
+	body, err := json.Marshal(map[string]string{"status": status})
+	if err != nil {
+		return err
+	}
+	return sendJSON("PUT", fmt.Sprintf("%s/%d", g.url(), g.id), g.headers(), body, nil)
+}
+
+// giteaDeployment tracks a deploy as commit status, Gitea has no
+// deployments API. The status context is named after the environment.
+type giteaDeployment struct {
+	p   Plugin
+	api string
+}
+
+func (g *giteaDeployment) start() error {
+	return g.status("pending", "deploying")
+}
+
+func (g *giteaDeployment) finish(execErr error) error {
+	if execErr != nil {
+		return g.status("failure", execErr.Error())
+	}
+	return g.status("success", "deployed")
+}
+
+func (g *giteaDeployment) status(state, description string) error {
+	return postJSON(fmt.Sprintf("%s/repos/%s/statuses/%s", g.api, g.p.Build.Repo, g.p.Build.Commit),
+		map[string]string{"Authorization": "token " + g.p.GiteaToken},
+		map[string]string{
+			"state":       state,
+			"context":     "deploy/" + g.p.Environment,
+			"target_url":  g.p.Build.Link,
+			"description": description,
+		})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var reANSI = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// diffPackage shows the changes an upgrade would apply using the helm-diff
+// plugin. The output is kept for the pull request comment. With FailOnDiff
+// any change fails the action.
+// helm diff upgrade $RELEASE $PACKAGE-$PLUGIN_CHART_VERSION.tgz --allow-unreleased
+func (p Plugin) diffPackage() error {
+	installed, err := p.helmPluginInstalled("diff")
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("diff needs the helm-diff plugin, which %s doesn't have", p.helm())
+	}
+	chart, err := p.chartArg()
+	if err != nil {
+		return err
+	}
+	values := append(p.plainValues(), fmt.Sprintf("namespace=%s", p.Namespace))
+
+	helmcmd := fmt.Sprintf("%s diff upgrade %s %s --set %s --allow-unreleased --namespace %s",
+		p.helm(),
+		p.Release,
+		chart,
+		shellQuote(strings.Join(values, ",")),
+		p.Namespace,
+	)
+	if p.NoColor {
+		helmcmd += " --no-color"
+	}
+	files, cleanup, err := p.valuesFileArgs()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if files != "" {
+		helmcmd = fmt.Sprintf("%s %s", helmcmd, files)
+	}
+	secrets, err := p.secretSetArgs()
+	if err != nil {
+		return err
+	}
+	if secrets != "" {
+		helmcmd = fmt.Sprintf("%s %s", helmcmd, secrets)
+	}
+	helmcmd += p.imageTagArgs() + p.droneMetadataArgs()
+	helmcmd += p.helmShellArgs(diffPkg)
+
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", helmcmd)
+	cmd.Env = os.Environ()
+	cmd.Stdout = &out
+	err = p.run(cmd)
+	// the diff is logged and posted to the pull request, secret values
+	// must not leak
+	fmt.Print(p.mask.apply(out.String()))
+	diff := p.mask.apply(reANSI.ReplaceAllString(out.String(), ""))
+	p.report.addDiff(p.target, diff)
+	if err == nil && p.FailOnDiff && strings.TrimSpace(diff) != "" {
+		return fmt.Errorf("release %s would change", p.Release)
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+)
+
+// drift compares the manifests stored with the deployed release to the
+// live objects of the cluster and reports out-of-band changes. It fails on
+// drift with DriftFail, otherwise it warns.
+// helm get manifest $RELEASE | kubectl diff -f - --namespace $PLUGIN_NAMESPACE
+func (p Plugin) drift() error {
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return err
+	}
+	var manifest bytes.Buffer
+	cmd := exec.Command(p.helm(), append([]string{"get", "manifest", p.Release}, namespace...)...)
+	cmd.Stdout = &manifest
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+
+	cmd = exec.Command(p.kubectl(), "diff", "-f", "-", "--namespace", p.Namespace)
+	cmd.Stdin = &manifest
+	cmd.Stdout = os.Stdout
+	err = p.run(cmd)
+	// kubectl diff exits with 1 when there are differences
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		log := logrus.WithField("release", p.Release)
+		if p.DriftFail {
+			log.Error("live objects drifted from the release")
+			return fmt.Errorf("release %s drifted", p.Release)
+		}
+		log.Warn("live objects drifted from the release")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logrus.WithField("release", p.Release).Info("no drift")
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+// Build holds the Drone build metadata read from the DRONE_* environment.
+type Build struct {
+	Repo        string `envconfig:"REPO" json:"repo"`
+	RepoLink    string `envconfig:"REPO_LINK" json:"repo_link"`
+	Number      string `envconfig:"BUILD_NUMBER" json:"build_number"`
+	Event       string `envconfig:"BUILD_EVENT" json:"build_event"`
+	Link        string `envconfig:"BUILD_LINK" json:"build_link"`
+	Commit      string `envconfig:"COMMIT_SHA" json:"commit_sha"`
+	Before      string `envconfig:"COMMIT_BEFORE" json:"commit_before"`
+	Branch      string `envconfig:"COMMIT_BRANCH" json:"commit_branch"`
+	Author      string `envconfig:"COMMIT_AUTHOR" json:"commit_author"`
+	Message     string `envconfig:"COMMIT_MESSAGE" json:"commit_message"`
+	Tag         string `envconfig:"TAG" json:"tag"`
+	PullRequest string `envconfig:"PULL_REQUEST" json:"pull_request"`
+	DeployTo    string `envconfig:"DEPLOY_TO" json:"deploy_to"`
+}
+
+// shortCommit returns the abbreviated commit sha.
+func (b Build) shortCommit() string {
+	if len(b.Commit) > 8 {
+		return b.Commit[:8]
+	}
+	return b.Commit
+}
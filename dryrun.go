@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// dryRunActions are the actions executed in dry run mode. They don't
+// change the chart repository or the cluster, or, like push and deploy,
+// only print respectively validate what they would change. All other
+// actions are skipped.
+var dryRunActions = map[string]bool{
+	lintPkg:   true,
+	createPkg: true,
+	pushPkg:   true,
+	pullPkg:   true,
+	deployPkg: true,
+	diffPkg:   true,
+	driftPkg:  true,
+
+	templatePkg: true,
+}
+
+// printCommand prints cmd as it would be run instead of running it.
+func (p Plugin) printCommand(cmd *exec.Cmd) {
+	p.addExtraArgs(cmd)
+	fmt.Printf("dry run: %s\n", strings.Join(p.mask.args(cmd.Args), " "))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// createDeployEvent records the deploy as Kubernetes Event in the target
+// namespace so it shows up in kubectl get events and cluster dashboards.
+// kubectl create -f - --namespace $PLUGIN_NAMESPACE
+func (p Plugin) createDeployEvent() error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	event, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"name":      fmt.Sprintf("%s.%x", p.Release, time.Now().UnixNano()),
+			"namespace": p.Namespace,
+			"labels": map[string]string{
+				"release": p.Release,
+			},
+		},
+		"involvedObject": map[string]string{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"name":       p.Namespace,
+		},
+		"reason":         "DroneDeploy",
+		"message":        fmt.Sprintf("Deployed %s %s as %s (%s)", p.Package, p.ChartVersion, p.Release, p.Build.Link),
+		"type":           "Normal",
+		"source":         map[string]string{"component": "drone-gcloud-helm"},
+		"firstTimestamp": now,
+		"lastTimestamp":  now,
+		"count":          1,
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(p.kubectl(), "create", "-f", "-", "--namespace", p.Namespace)
+	cmd.Stdin = bytes.NewReader(event)
+	return p.run(cmd)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// gcloudConfigPrefix prefixes the names of the gcloud configurations of
+	// the runs.
+	gcloudConfigPrefix = "drone-gcloud-helm-"
+
+	// gcloudConfigEnv selects the gcloud configuration of all gcloud
+	// commands.
+	gcloudConfigEnv = "CLOUDSDK_ACTIVE_CONFIG_NAME"
+)
+
+// useGcloudConfig creates a gcloud configuration for this run and selects it
+// for all following gcloud commands, so the account and project set by the
+// run don't change the configuration of concurrent runs sharing the SDK
+// configuration directory. The configuration is created once per run and
+// not at all when a configuration is selected already.
+// gcloud config configurations create $NAME --no-activate
+func (p Plugin) useGcloudConfig() error {
+	if os.Getenv(gcloudConfigEnv) != "" {
+		return nil
+	}
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := gcloudConfigPrefix + hex.EncodeToString(suffix)
+	cmd := exec.Command(gcloudBin, "config", "configurations", "create", name, "--no-activate")
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	logrus.WithField("configuration", name).Info("using gcloud configuration")
+	return os.Setenv(gcloudConfigEnv, name)
+}
+
+// deleteGcloudConfig deletes the gcloud configuration of this run, if any.
+// gcloud config configurations delete $NAME --quiet
+func (p Plugin) deleteGcloudConfig() error {
+	name := os.Getenv(gcloudConfigEnv)
+	if !strings.HasPrefix(name, gcloudConfigPrefix) {
+		return nil
+	}
+	// the active configuration can't be deleted
+	if err := os.Unsetenv(gcloudConfigEnv); err != nil {
+		return err
+	}
+	cmd := exec.Command(gcloudBin, "config", "configurations", "delete", name, "--quiet")
+	return p.run(cmd)
+}
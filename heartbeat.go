@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// heartbeat logs the elapsed time and the pod readiness of the release
+// every HeartbeatInterval until the returned function is called, so long
+// waits don't look hung.
+func (p Plugin) heartbeat(what string) func() {
+	if p.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	started := time.Now()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(p.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				entry := logrus.WithField("elapsed", time.Since(started).Round(time.Second).String())
+				if ready, total, err := p.podReadiness(); err == nil {
+					entry = entry.WithField("pods", fmt.Sprintf("%d/%d ready", ready, total))
+				}
+				entry.Infof("still waiting for %s", what)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// podReadiness counts the ready pods of the release. It does not use p.run
+// as it runs concurrently to the awaited command.
+// kubectl get pods --namespace $PLUGIN_NAMESPACE -l release=$RELEASE -o json
+func (p Plugin) podReadiness() (ready, total int, err error) {
+	cmd := exec.Command(p.kubectl(), "get", "pods",
+		"--namespace", p.Namespace,
+		"-l", "release="+p.Release,
+		"-o", "json",
+	)
+	cmd.Env = append(os.Environ(), p.env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := p.exec(cmd); err != nil {
+		return 0, 0, err
+	}
+
+	var pods struct {
+		Items []struct {
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &pods); err != nil {
+		return 0, 0, err
+	}
+	for _, pod := range pods.Items {
+		for _, c := range pod.Status.Conditions {
+			if c.Type == "Ready" && c.Status == "True" {
+				ready++
+			}
+		}
+	}
+	return ready, len(pods.Items), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// helmVersionsDir holds the bundled helm clients as <version>/helm, e.g.
+// /opt/helm/v3.4.2/helm.
+const helmVersionsDir = "/opt/helm"
+
+// helm returns the path of the selected helm client.
+func (p Plugin) helm() string {
+	if p.helmPath != "" {
+		return p.helmPath
+	}
+	return helmBin
+}
+
+// helmNamespaceArgs returns the --namespace flag the release commands,
+// like get, history, rollback and uninstall, need on Helm 3, whose releases
+// are namespaced. Helm 2 releases are not and its commands have no such
+// flag.
+func (p Plugin) helmNamespaceArgs() ([]string, error) {
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return nil, err
+	}
+	if major == 3 {
+		return []string{"--namespace", p.Namespace}, nil
+	}
+	return nil, nil
+}
+
+// kubectl returns the path of the selected kubectl.
+func (p Plugin) kubectl() string {
+	if p.kubectlPath != "" {
+		return p.kubectlPath
+	}
+	return kubectlBin
+}
+
+// resolveHelm returns the path of the newest bundled helm client matching
+// version, e.g. 2.17, v3.4 or 3.4.2. An empty version selects the default
+// client.
+func resolveHelm(version string) (string, error) {
+	if version == "" {
+		return helmBin, nil
+	}
+	version = "v" + strings.TrimPrefix(version, "v")
+
+	dirs, err := ioutil.ReadDir(helmVersionsDir)
+	if err != nil {
+		return "", err
+	}
+	var available []string
+	for _, d := range dirs {
+		available = append(available, d.Name())
+	}
+	sort.Slice(available, func(i, j int) bool {
+		return versionLess(available[j], available[i])
+	})
+	for _, v := range available {
+		if v == version || strings.HasPrefix(v, version+".") {
+			return filepath.Join(helmVersionsDir, v, "helm"), nil
+		}
+	}
+	return "", fmt.Errorf("helm %s is not bundled, available: %s", version, strings.Join(available, ", "))
+}
+
+// versionLess compares the dot separated numeric parts of two versions
+// such as v3.4.2.
+func versionLess(a, b string) bool {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, _ := strconv.Atoi(pa[i])
+		nb, _ := strconv.Atoi(pb[i])
+		if na != nb {
+			return na < nb
+		}
+	}
+	return len(pa) < len(pb)
+}
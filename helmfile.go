@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// helmfileCommands are the supported helmfile commands.
+var helmfileCommands = map[string]bool{
+	"apply": true,
+	"diff":  true,
+	"sync":  true,
+}
+
+// helmfile runs helmfile against the helmfile of the repository using the
+// prepared cluster credentials. The output of diff is kept for the pull
+// request comment.
+// helmfile --file $PLUGIN_HELMFILE --helm-binary helm --environment $PLUGIN_HELMFILE_ENVIRONMENT apply
+func (p Plugin) helmfile() error {
+	if !helmfileCommands[p.HelmfileCommand] {
+		return fmt.Errorf("unsupported helmfile command: %s", p.HelmfileCommand)
+	}
+
+	args := []string{"--file", p.Helmfile, "--helm-binary", p.helm()}
+	if p.HelmfileEnvironment != "" {
+		args = append(args, "--environment", p.HelmfileEnvironment)
+	}
+	args = append(args, p.HelmfileCommand)
+	args = append(args, p.helmArgs(helmfilePkg)...)
+
+	var out bytes.Buffer
+	cmd := exec.Command(helmfileBin, args...)
+	if p.HelmfileCommand == "diff" {
+		cmd.Stdout = &out
+	}
+	err := p.run(cmd)
+	if p.HelmfileCommand == "diff" {
+		// see diffPackage
+		fmt.Print(p.mask.apply(out.String()))
+		p.report.addDiff(p.target, p.mask.apply(reANSI.ReplaceAllString(out.String(), "")))
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// objectExists reports whether the GCS object url exists.
+// gsutil -q stat $URL
+func (p Plugin) objectExists(url string) (bool, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(gsutilBin, "-q", "stat", url)
+	cmd.Stderr = &stderr
+	if err := p.run(cmd); err != nil {
+		// gsutil -q stat exits with 1 and no output for a missing object
+		if strings.TrimSpace(stderr.String()) == "" || strings.Contains(stderr.String(), "No URLs matched") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// publishedPackage checks whether the package was pushed to the bucket or
+// the OCI registry before. It fails when the package exists with Immutable,
+// and reports true when it exists with SkipExisting, so the push is skipped.
+func (p Plugin) publishedPackage() (bool, error) {
+	if !p.Immutable && !p.SkipExisting {
+		return false, nil
+	}
+	var url string
+	var exists bool
+	var err error
+	if p.Bucket != "" {
+		url = fmt.Sprintf("gs://%s/%s-%s.tgz", p.Bucket, p.Package, p.ChartVersion)
+		if exists, err = p.objectExists(url); err != nil {
+			return false, err
+		}
+	}
+	if !exists && p.OCIRegistry != "" {
+		url = fmt.Sprintf("%s/%s:%s", p.ociRepository(), p.Package, p.ChartVersion)
+		if exists, err = p.ociChartExists(); err != nil {
+			return false, err
+		}
+	}
+	if !exists {
+		return false, nil
+	}
+	if p.SkipExisting {
+		logrus.WithField("package", url).Info("package was published already, skipping push")
+		return true, nil
+	}
+	return false, fmt.Errorf("%s was published already, published chart versions are immutable", url)
+}
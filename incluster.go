@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// runningInCluster reports whether the plugin runs in a Kubernetes pod with
+// a mounted service account, e.g. on the Drone Kubernetes runner.
+func runningInCluster() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
+	}
+	_, err := os.Stat(serviceAccountTokenFile)
+	return err == nil
+}
+
+// setupInCluster writes a kubeconfig for the cluster the plugin runs in
+// using the mounted service account and prepares helm.
+func (p Plugin) setupInCluster() (err error) {
+	s := p.tracer.start("setup", nil)
+	defer func() { p.tracer.finish(s, err) }()
+
+	token, err := ioutil.ReadFile(serviceAccountTokenFile)
+	if err != nil {
+		return err
+	}
+	server := "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	cfg := map[string]interface{}{
+		"apiVersion":      "v1",
+		"kind":            "Config",
+		"current-context": "in-cluster",
+		"clusters": []interface{}{map[string]interface{}{
+			"name": "in-cluster",
+			"cluster": map[string]interface{}{
+				"server":                server,
+				"certificate-authority": filepath.Join(filepath.Dir(serviceAccountTokenFile), "ca.crt"),
+			},
+		}},
+		"users": []interface{}{map[string]interface{}{
+			"name": "in-cluster",
+			"user": map[string]interface{}{"token": strings.TrimSpace(string(token))},
+		}},
+		"contexts": []interface{}{map[string]interface{}{
+			"name": "in-cluster",
+			"context": map[string]interface{}{
+				"cluster":   "in-cluster",
+				"user":      "in-cluster",
+				"namespace": p.Namespace,
+			},
+		}},
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	path := p.kubeconfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+
+	if p.KubeAs != "" || len(p.KubeAsGroup) > 0 {
+		if err := p.impersonate(); err != nil {
+			return err
+		}
+	}
+	return p.helmInit()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// indexAttempts limits the index updates lost to concurrent updates.
+const indexAttempts = 5
+
+// errIndexChanged is returned when index.yaml changed since it was read.
+var errIndexChanged = errors.New("index.yaml changed concurrently")
+
+var generationPattern = regexp.MustCompile(`(?m)^\s*Generation:\s*(\d+)`)
+
+// indexURL returns the URL of the repository index in the bucket.
+func (p Plugin) indexURL() string {
+	return fmt.Sprintf("gs://%s/index.yaml", p.Bucket)
+}
+
+// indexGeneration returns the generation of the repository index, 0 when
+// the bucket has no index yet.
+// gsutil stat gs://$PLUGIN_BUCKET/index.yaml
+func (p Plugin) indexGeneration() (int64, error) {
+	var out, stderr bytes.Buffer
+	cmd := exec.Command(gsutilBin, "stat", p.indexURL())
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := p.run(cmd); err != nil {
+		if strings.Contains(stderr.String(), "No URLs matched") {
+			return 0, nil
+		}
+		return 0, err
+	}
+	m := generationPattern.FindStringSubmatch(out.String())
+	if m == nil {
+		return 0, errors.New("no generation of " + p.indexURL())
+	}
+	return strconv.ParseInt(m[1], 10, 64)
+}
+
+// updateIndex adds the pushed package to the repository index.
+func (p Plugin) updateIndex() error {
+	return p.retryIndex(p.mergeIndex)
+}
+
+// retryIndex calls update until it did not fail with errIndexChanged. The
+// index is only replaced if its generation did not change since it was
+// read, so concurrent updates cannot drop each other's changes. When it
+// changed, update reads and changes the index again.
+func (p Plugin) retryIndex(update func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := update()
+		if err != errIndexChanged {
+			return err
+		}
+		if attempt == indexAttempts {
+			return fmt.Errorf("%s, gave up after %d attempts", err, attempt)
+		}
+		logrus.WithField("attempt", attempt).Warn("index.yaml changed concurrently, updating again")
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// mergeIndex merges the package into the current repository index and
+// uploads it.
+// helm repo index DIR --url $PLUGIN_CHART_REPO --merge index.yaml
+func (p Plugin) mergeIndex() error {
+	generation, err := p.indexGeneration()
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	charts := filepath.Join(dir, "charts")
+	if err := os.Mkdir(charts, 0755); err != nil {
+		return err
+	}
+	pkg := fmt.Sprintf("%s-%s.tgz", p.Package, p.ChartVersion)
+	if err := cp(pkg, filepath.Join(charts, pkg)); err != nil {
+		return err
+	}
+
+	var existing string
+	if generation != 0 {
+		existing = filepath.Join(dir, "index.yaml")
+		if err := p.cpPackage(p.indexURL(), existing); err != nil {
+			return err
+		}
+	}
+	if err := p.indexRepo(charts, existing); err != nil {
+		return err
+	}
+
+	return p.uploadIndex(filepath.Join(charts, "index.yaml"), generation)
+}
+
+// uploadIndex replaces the repository index by file if its generation is
+// still generation.
+// gsutil -h x-goog-if-generation-match:GENERATION cp index.yaml gs://$PLUGIN_BUCKET
+func (p Plugin) uploadIndex(file string, generation int64) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(gsutilBin,
+		"-h", fmt.Sprintf("x-goog-if-generation-match:%d", generation),
+		"cp", file, p.indexURL(),
+	)
+	cmd.Stderr = &stderr
+	if err := p.run(cmd); err != nil {
+		if s := stderr.String(); strings.Contains(s, "PreconditionException") || strings.Contains(s, "412") {
+			return errIndexChanged
+		}
+		return err
+	}
+	logrus.WithField("generation", generation).Info("updated index.yaml")
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	atlassianTokenURL = "https://api.atlassian.com/oauth/token"
+	jiraDeploymentURL = "https://api.atlassian.com/jira/deployments/0.1/cloud/%s/bulk"
+)
+
+var reIssueKey = regexp.MustCompile(`\b[A-Z][A-Z0-9]+-[0-9]+\b`)
+
+// issueKeys returns the unique Jira issue keys referenced in texts.
+func issueKeys(texts ...string) []string {
+	keys := make([]string, 0)
+	for _, t := range texts {
+		for _, k := range reIssueKey.FindAllString(t, -1) {
+			if !contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+// jiraDeployment sends the deployment to Jira so the issues referenced in
+// the commit message and branch name show where they were shipped.
+func (p Plugin) jiraDeployment(execErr error) error {
+	keys := issueKeys(p.Build.Message, p.Build.Branch)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	token, err := p.jiraToken()
+	if err != nil {
+		return err
+	}
+
+	state := "successful"
+	if execErr != nil {
+		state = "failed"
+	}
+	number, _ := strconv.ParseInt(p.Build.Number, 10, 64)
+	body, err := json.Marshal(map[string]interface{}{
+		"deployments": []interface{}{
+			map[string]interface{}{
+				"deploymentSequenceNumber": number,
+				"updateSequenceNumber":     time.Now().Unix(),
+				"associations": []interface{}{
+					map[string]interface{}{
+						"associationType": "issueIdOrKeys",
+						"values":          keys,
+					},
+				},
+				"displayName": fmt.Sprintf("%s %s", p.Package, p.ChartVersion),
+				"url":         p.Build.Link,
+				"description": p.summary(execErr),
+				"lastUpdated": time.Now().UTC().Format(time.RFC3339),
+				"state":       state,
+				"pipeline": map[string]string{
+					"id":          p.Build.Repo,
+					"displayName": p.Build.Repo,
+					"url":         p.Build.RepoLink,
+				},
+				"environment": map[string]string{
+					"id":          p.Environment,
+					"displayName": p.Environment,
+					"type":        p.JiraEnvironmentType,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		RejectedDeployments []struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"rejectedDeployments"`
+	}
+	if err := sendJSON("POST", fmt.Sprintf(jiraDeploymentURL, p.JiraCloudID), map[string]string{
+		"Authorization": "Bearer " + token,
+	}, body, &resp); err != nil {
+		return err
+	}
+	for _, r := range resp.RejectedDeployments {
+		if len(r.Errors) > 0 {
+			return fmt.Errorf("jira rejected deployment: %s", r.Errors[0].Message)
+		}
+	}
+	return nil
+}
+
+// jiraToken fetches an access token using the OAuth client credentials of
+// the Jira integration.
+func (p Plugin) jiraToken() (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"audience":      "api.atlassian.com",
+		"grant_type":    "client_credentials",
+		"client_id":     p.JiraClientID,
+		"client_secret": p.JiraClientSecret,
+	})
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := sendJSON("POST", atlassianTokenURL, nil, body, &token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kubeconfigPath returns the path of the kubeconfig used by kubectl and
+// helm.
+func (p Plugin) kubeconfigPath() string {
+	path := os.Getenv("KUBECONFIG")
+	for _, e := range p.env {
+		if strings.HasPrefix(e, "KUBECONFIG=") {
+			path = strings.TrimPrefix(e, "KUBECONFIG=")
+		}
+	}
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	}
+	return path
+}
+
+// editKubeconfig applies edit to the kubeconfig.
+func (p Plugin) editKubeconfig(edit func(cfg map[string]interface{}) error) error {
+	path := p.kubeconfigPath()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]interface{}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	if err := edit(cfg); err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0600)
+}
+
+// currentEntry returns the entry of list (clusters or users) the current
+// context refers to by key (cluster or user).
+func currentEntry(cfg map[string]interface{}, list, key string) (map[interface{}]interface{}, error) {
+	current, _ := cfg["current-context"].(string)
+	var name string
+	for _, c := range namedEntries(cfg["contexts"]) {
+		if c["name"] == current {
+			if ctx, ok := c["context"].(map[interface{}]interface{}); ok {
+				name, _ = ctx[key].(string)
+			}
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no %s in current kubeconfig context", key)
+	}
+
+	for _, e := range namedEntries(cfg[list]) {
+		if e["name"] != name {
+			continue
+		}
+		m, ok := e[key].(map[interface{}]interface{})
+		if !ok {
+			m = make(map[interface{}]interface{})
+			e[key] = m
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s %s not found in kubeconfig", key, name)
+}
+
+// impersonate sets KubeAs and KubeAsGroup as impersonated user and groups
+// of the user of the current kubeconfig context, so kubectl and helm act
+// as them.
+func (p Plugin) impersonate() error {
+	return p.editKubeconfig(func(cfg map[string]interface{}) error {
+		user, err := currentEntry(cfg, "users", "user")
+		if err != nil {
+			return err
+		}
+		if p.KubeAs != "" {
+			user["as"] = p.KubeAs
+		}
+		if len(p.KubeAsGroup) > 0 {
+			user["as-groups"] = p.KubeAsGroup
+		}
+		return nil
+	})
+}
+
+// setKubeProxy sets KubeProxyURL as proxy-url of the cluster of the
+// current kubeconfig context.
+func (p Plugin) setKubeProxy() error {
+	return p.editKubeconfig(func(cfg map[string]interface{}) error {
+		cluster, err := currentEntry(cfg, "clusters", "cluster")
+		if err != nil {
+			return err
+		}
+		cluster["proxy-url"] = p.KubeProxyURL
+		return nil
+	})
+}
+
+// namedEntries returns the entries of a kubeconfig list such as contexts
+// or users.
+func namedEntries(list interface{}) []map[interface{}]interface{} {
+	var entries []map[interface{}]interface{}
+	l, _ := list.([]interface{})
+	for _, e := range l {
+		if m, ok := e.(map[interface{}]interface{}); ok {
+			entries = append(entries, m)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+)
+
+// kustomize builds the kustomization at KustomizePath and applies the
+// result, optionally pruning the objects matching KustomizeSelector which
+// are no longer part of it.
+// kustomize build $PLUGIN_KUSTOMIZE_PATH | kubectl apply -f - --namespace $PLUGIN_NAMESPACE --prune -l $PLUGIN_KUSTOMIZE_SELECTOR
+func (p Plugin) kustomize() error {
+	if p.KustomizePrune && p.KustomizeSelector == "" {
+		return errors.New("kustomize_prune requires a kustomize_selector")
+	}
+
+	var manifests bytes.Buffer
+	build := exec.Command(kustomizeBin, "build", p.KustomizePath)
+	build.Stdout = &manifests
+	if err := p.run(build); err != nil {
+		return err
+	}
+
+	args := []string{"apply", "-f", "-", "--namespace", p.Namespace}
+	if p.KustomizeSelector != "" {
+		args = append(args, "-l", p.KustomizeSelector)
+	}
+	if p.KustomizePrune {
+		args = append(args, "--prune")
+	}
+	apply := exec.Command(p.kubectl(), args...)
+	apply.Stdin = &manifests
+	return p.run(apply)
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -16,38 +18,121 @@ func main() {
 	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
 		godotenv.Load(env)
 	}
+	if err := mapCIEnv(); err != nil {
+		logrus.WithError(err).Fatal("failed to map CI environment")
+	}
+	if err := normalizeSettings(); err != nil {
+		logrus.WithError(err).Fatal("failed to normalize parameters")
+	}
 
 	var p Plugin
 	if err := envconfig.Process("plugin", &p); err != nil {
 		logrus.WithError(err).Fatal("failed to parse parameters")
 	}
+	if err := envconfig.Process("drone", &p.Build); err != nil {
+		logrus.WithError(err).Fatal("failed to parse build metadata")
+	}
+	warnUnknownSettings()
+	for i, v := range p.Values {
+		p.Values[i] = expandEnv(v)
+	}
+	for i, v := range p.ValuesFiles {
+		p.ValuesFiles[i] = expandEnv(v)
+	}
+	if err := setProxyEnv(p); err != nil {
+		logrus.WithError(err).Fatal("failed to configure proxy")
+	}
+	if err := setHelmEnv(p); err != nil {
+		logrus.WithError(err).Fatal("failed to configure helm directories")
+	}
 	if p.ShowEnv {
-		for _, e := range os.Environ() {
-			pair := strings.Split(e, "=")
-			fmt.Println(pair[0])
-		}
+		showEnv(p.SecretKeys)
 	}
 	if p.Debug {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+	var logHook *cloudLogHook
+	if p.CloudLogging {
+		logHook = &cloudLogHook{}
+		logrus.AddHook(logHook)
+	}
+	// NO_COLOR wins over COLOR, otherwise logrus detects the terminal itself
+	switch {
+	case p.NoColor:
+		logrus.SetFormatter(&logrus.TextFormatter{DisableColors: true})
+	case p.Color:
+		logrus.SetFormatter(&logrus.TextFormatter{ForceColors: true})
+	}
+
+	if err := p.retry("prepare plugin", func() error { return preparePlugin(&p) }); err != nil {
+		logrus.WithError(err).Fatal("failed to prepare plugin")
+	}
 
-	if err := preparePlugin(&p); err != nil {
-		logrus.Warn("Prepare plugin failed. Waiting 10 seconds and retrying!")
-		time.Sleep(10 * time.Second) // wait and retry
-		if err := preparePlugin(&p); err != nil {
-			logrus.WithError(err).Fatal("failed to prepare plugin")
+	tracker := p.deploymentTracker()
+	if tracker != nil {
+		if err := tracker.start(); err != nil {
+			logrus.WithError(err).Warn("failed to create deployment")
 		}
 	}
 
-	if err := p.Exec(); err != nil {
-		logrus.Warn("Plugin execution failed. Waiting 10 seconds and retrying!")
-		if err := p.Exec(); err != nil {
-			logrus.WithError(err).Fatal("failed to execute plugin")
+	started := time.Now()
+	// the actions retry on their own, see runAction
+	err := p.Exec()
+	logrus.WithFields(logrus.Fields{
+		"commands": p.report.commandTime().Round(time.Millisecond).String(),
+		"total":    time.Since(started).Round(time.Millisecond).String(),
+	}).Info("execution finished")
+	if err := p.tracer.export(); err != nil {
+		logrus.WithError(err).Warn("failed to export traces")
+	}
+	if logHook != nil {
+		if err := p.shipLogs(logHook, started, err); err != nil {
+			logrus.WithError(err).Warn("failed to write logs to cloud logging")
 		}
 	}
+	if tracker != nil {
+		if err := tracker.finish(err); err != nil {
+			logrus.WithError(err).Warn("failed to update deployment status")
+		}
+	}
+	p.notify(started, err)
+	// last, the steps above still use the account of the configuration
+	if err := p.deleteGcloudConfig(); err != nil {
+		logrus.WithError(err).Warn("failed to delete gcloud configuration")
+	}
+	if err != nil {
+		logrus.WithError(err).Error("failed to execute plugin")
+		os.Exit(exitCode(err))
+	}
 }
 
 func preparePlugin(p *Plugin) error {
+	var skipped []string
+	p.Actions, skipped = p.ActionSpecs.enabled(p.Build)
+	for _, a := range skipped {
+		logrus.WithField("action", a).Info("skipping action, condition not met")
+	}
+	if err := p.expandChartPaths(); err != nil {
+		return err
+	}
+	if p.ChartPath == "" && len(p.Charts) == 0 && p.RemoteChart == "" {
+		return errors.New("chart_path, charts or remote_chart is required")
+	}
+	if _, err := p.Charts.sorted(); err != nil {
+		return err
+	}
+	if p.Zone != "" && p.Region != "" {
+		return errors.New("set either zone or region, not both")
+	}
+	if err := p.resolveAuthKey(); err != nil {
+		return err
+	}
+	if err := p.validateAuthMode(); err != nil {
+		return err
+	}
+	if p.Package == "" && p.ChartPath == "" && p.RemoteChart != "" {
+		p.Package = p.remoteChartName()
+	}
 	if p.Package == "" {
 		s := strings.Split(p.ChartPath, "/")
 		p.Package = s[len(s)-1]
@@ -55,12 +140,164 @@ func preparePlugin(p *Plugin) error {
 	if p.Release == "" {
 		p.Release = p.Package
 	}
+	source, err := p.resolveChartVersion()
+	if err != nil {
+		return err
+	}
+	if err := p.resolveChartVersions(); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"version": p.ChartVersion,
+		"source":  source,
+	}).Info("chart version")
 	if p.ChartRepo == "" && p.Bucket != "" {
 		p.ChartRepo = fmt.Sprintf("https://%s.storage.googleapis.com/", p.Bucket)
 	}
+	helmPath, err := resolveHelm(p.HelmVersion)
+	if err != nil {
+		return err
+	}
+	p.helmPath = helmPath
+	if p.HelmDownloadVersion != "" {
+		if p.helmPath, err = p.downloadHelm(p.HelmDownloadVersion); err != nil {
+			return err
+		}
+	}
+	if p.KubectlDownloadVersion != "" {
+		if p.kubectlPath, err = p.downloadKubectl(p.KubectlDownloadVersion); err != nil {
+			return err
+		}
+	}
+	if p.NamespaceTemplate != "" {
+		ns, err := p.renderNamespace()
+		if err != nil {
+			return err
+		}
+		p.Namespace = ns
+		logrus.WithField("namespace", ns).Info("namespace from template")
+	}
 	if p.Namespace == "" {
 		p.Namespace = "default"
 	}
+	if err := p.useClusters(); err != nil {
+		return err
+	}
+	if len(p.Projects) > 0 {
+		p.Targets = p.projectTargets()
+		p.Projects = nil
+	}
+	if !p.InCluster && !p.authenticated() && len(p.Targets) == 0 && runningInCluster() {
+		for _, a := range p.Actions {
+			if clusterActions[a] {
+				logrus.Info("using the in-cluster service account")
+				p.InCluster = true
+				break
+			}
+		}
+	}
+	if p.Environment == "" {
+		p.Environment = p.Build.DeployTo
+	}
+	if p.Environment == "" {
+		p.Environment = p.Namespace
+	}
+	if p.LogFile != "" && p.cmdLog == nil {
+		f, err := os.OpenFile(p.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		p.cmdLog = f
+	}
+	if p.report == nil {
+		p.report = &report{}
+	}
+	if p.mask == nil {
+		p.mask = &mask{}
+	}
+	if p.auth == nil {
+		p.auth = &authCache{}
+	}
+	if p.runner == nil {
+		p.runner = execRunner{}
+	}
+	for _, entry := range envSecretValues() {
+		p.mask.add(strings.SplitN(entry, "=", 2)[1])
+	}
+	// never log the key, not even in debug mode
+	p.mask.add(p.AuthKey)
+	if p.OtlpEndpoint != "" && p.tracer == nil {
+		p.tracer = newTracer(p.OtlpEndpoint, p.OtlpHeaders)
+	}
+	// runs git, so it comes after the runner is set up
+	p.selectChangedCharts()
+
+	return nil
+}
+
+// setProxyEnv exports the proxy parameters in the upper and lower case
+// variants honored by gcloud, helm, kubectl and the plugin itself.
+func setProxyEnv(p Plugin) error {
+	for name, value := range map[string]string{
+		"HTTPS_PROXY": p.HTTPSProxy,
+		"NO_PROXY":    p.NoProxy,
+	} {
+		if value == "" {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+		if err := os.Setenv(strings.ToLower(name), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// setHelmEnv exports the helm directory parameters as absolute paths, so
+// they can point into the workspace. They default to directories in the
+// CacheDir.
+func setHelmEnv(p Plugin) error {
+	if p.CacheDir != "" {
+		if p.HelmHome == "" {
+			p.HelmHome = filepath.Join(p.CacheDir, "helm")
+		}
+		if p.HelmCacheHome == "" {
+			p.HelmCacheHome = filepath.Join(p.CacheDir, "cache")
+		}
+		if p.HelmConfigHome == "" {
+			p.HelmConfigHome = filepath.Join(p.CacheDir, "config")
+		}
+	}
+	for name, value := range map[string]string{
+		"HELM_HOME":        p.HelmHome,
+		"HELM_CACHE_HOME":  p.HelmCacheHome,
+		"HELM_CONFIG_HOME": p.HelmConfigHome,
+	} {
+		if value == "" {
+			continue
+		}
+		path, err := filepath.Abs(value)
+		if err != nil {
+			return err
+		}
+		if err := os.Setenv(name, path); err != nil {
+			return err
+		}
+	}
+	// keep the plugins installed into the default home of the image
+	if p.HelmHome != "" && os.Getenv("HELM_PLUGIN") == "" {
+		return os.Setenv("HELM_PLUGIN", filepath.Join(os.Getenv("HOME"), ".helm", "plugins"))
+	}
 	return nil
 }
+
+// expandEnv replaces ${VAR} and $VAR references with the values of the
+// environment variables. $$ escapes a literal dollar sign.
+func expandEnv(s string) string {
+	const escaped = "\x00"
+	s = strings.Replace(s, "$$", escaped, -1)
+	s = os.ExpandEnv(s)
+	return strings.Replace(s, escaped, "$", -1)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// templateFile returns the file the rendered manifests are written to:
+// TemplateOutput or, if it is a directory, $RELEASE.yaml in it.
+func (p Plugin) templateFile() string {
+	if strings.HasSuffix(p.TemplateOutput, "/") {
+		return filepath.Join(p.TemplateOutput, p.Release+".yaml")
+	}
+	if fi, err := os.Stat(p.TemplateOutput); err == nil && fi.IsDir() {
+		return filepath.Join(p.TemplateOutput, p.Release+".yaml")
+	}
+	return p.TemplateOutput
+}
+
+// template renders the chart package with the values of the deploy and
+// writes the manifests to templateFile, e.g. to be reviewed as artifact.
+// helm template $PACKAGE-$PLUGIN_CHART_VERSION.tgz > $PLUGIN_TEMPLATE_OUTPUT
+func (p Plugin) template() error {
+	manifests, err := p.renderManifests()
+	if err != nil {
+		return err
+	}
+	file := p.templateFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, []byte(manifests), 0644); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"release": p.Release,
+		"file":    file,
+	}).Info("wrote rendered manifests")
+	return nil
+}
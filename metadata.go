@@ -0,0 +1,48 @@
+package main
+
+import "strings"
+
+// droneMetadataValues returns the drone.* chart values InjectDroneMetadata
+// sets as key=value entries. Empty metadata and keys Values already set are
+// left out.
+func (p Plugin) droneMetadataValues() []string {
+	if !p.InjectDroneMetadata {
+		return nil
+	}
+	var entries []string
+	for _, kv := range [][2]string{
+		{"drone.commit", p.Build.Commit},
+		{"drone.build", p.Build.Number},
+		{"drone.branch", p.Build.Branch},
+		{"drone.repo", p.Build.Repo},
+		{"drone.tag", p.Build.Tag},
+	} {
+		if kv[1] == "" || p.setsValue(kv[0]) {
+			continue
+		}
+		entries = append(entries, kv[0]+"="+kv[1])
+	}
+	return entries
+}
+
+// droneMetadataArgs returns the --set-string flags of droneMetadataValues,
+// each prefixed with a space. String values keep build numbers and
+// branches like 1.0 from being read as numbers.
+func (p Plugin) droneMetadataArgs() string {
+	var args string
+	for _, entry := range p.droneMetadataValues() {
+		kv := strings.SplitN(entry, "=", 2)
+		args += " --set-string " + shellQuote(kv[0]+"="+escapeSetValue(kv[1]))
+	}
+	return args
+}
+
+// setsValue reports whether Values set key.
+func (p Plugin) setsValue(key string) bool {
+	for _, v := range p.Values {
+		if strings.HasPrefix(v, key+"=") {
+			return true
+		}
+	}
+	return false
+}
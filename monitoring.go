@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const monitoringTimeSeriesURL = "https://monitoring.googleapis.com/v3/projects/%s/timeSeries"
+
+// writeDeployMetrics writes the deploy count and duration as custom metrics
+// to Cloud Monitoring, labeled by cluster, namespace, chart and result.
+func (p Plugin) writeDeployMetrics() error {
+	var deploy *actionResult
+	for _, r := range p.report.results() {
+		if r.Action == deployPkg {
+			r := r
+			deploy = &r
+		}
+	}
+	if deploy == nil {
+		return nil
+	}
+
+	labels := map[string]string{
+		"cluster":   p.Cluster,
+		"namespace": p.Namespace,
+		"chart":     p.Package,
+		"release":   p.Release,
+		"result":    deploy.Status,
+	}
+	resource := map[string]interface{}{
+		"type":   "global",
+		"labels": map[string]string{"project_id": p.Project},
+	}
+	interval := map[string]string{"endTime": time.Now().UTC().Format(time.RFC3339Nano)}
+
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+	return postJSON(fmt.Sprintf(monitoringTimeSeriesURL, p.Project), map[string]string{
+		"Authorization": "Bearer " + token,
+	}, map[string]interface{}{
+		"timeSeries": []interface{}{
+			map[string]interface{}{
+				"metric":     map[string]interface{}{"type": p.MetricsPrefix + "/deploy_count", "labels": labels},
+				"resource":   resource,
+				"metricKind": "GAUGE",
+				"valueType":  "INT64",
+				"points": []interface{}{
+					map[string]interface{}{
+						"interval": interval,
+						"value":    map[string]string{"int64Value": "1"},
+					},
+				},
+			},
+			map[string]interface{}{
+				"metric":     map[string]interface{}{"type": p.MetricsPrefix + "/deploy_duration", "labels": labels},
+				"resource":   resource,
+				"metricKind": "GAUGE",
+				"valueType":  "DOUBLE",
+				"points": []interface{}{
+					map[string]interface{}{
+						"interval": interval,
+						"value":    map[string]string{"doubleValue": strconv.FormatFloat(deploy.Duration, 'f', -1, 64)},
+					},
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// notify reports the result of the plugin run to all configured
+// notification targets. Failures to notify are logged only.
+func (p Plugin) notify(started time.Time, execErr error) {
+	duration := time.Since(started)
+
+	if p.SlackWebhook != "" {
+		if err := p.notifySlack(duration, execErr); err != nil {
+			logrus.WithError(err).Warn("failed to notify slack")
+		}
+	}
+	if p.TeamsWebhook != "" {
+		if err := p.notifyTeams(duration, execErr); err != nil {
+			logrus.WithError(err).Warn("failed to notify teams")
+		}
+	}
+	if p.DiscordWebhook != "" {
+		if err := p.notifyDiscord(duration, execErr); err != nil {
+			logrus.WithError(err).Warn("failed to notify discord")
+		}
+	}
+	if p.NewrelicAPIKey != "" && p.NewrelicEntityGUID != "" && p.hasAction(deployPkg) && execErr == nil {
+		if err := p.newrelicMarker(); err != nil {
+			logrus.WithError(err).Warn("failed to create new relic deployment marker")
+		}
+	}
+	if p.JiraCloudID != "" && p.hasAction(deployPkg) {
+		if err := p.jiraDeployment(execErr); err != nil {
+			logrus.WithError(err).Warn("failed to send deployment to jira")
+		}
+	}
+	if p.DiffComment && p.hasAction(diffPkg) && p.Build.Event == "pull_request" && p.Build.PullRequest != "" {
+		comment := p.diffComment(p.report.diff(), p.DiffCommentLimit)
+		if err := p.commentPR(comment); err != nil {
+			logrus.WithError(err).Warn("failed to comment diff on pull request")
+		}
+	}
+	if p.BigqueryDataset != "" && p.BigqueryTable != "" {
+		if err := p.exportBigquery(); err != nil {
+			logrus.WithError(err).Warn("failed to export actions to bigquery")
+		}
+	}
+	if p.Metrics && p.Project != "" {
+		if err := p.writeDeployMetrics(); err != nil {
+			logrus.WithError(err).Warn("failed to write deploy metrics")
+		}
+	}
+	if p.StatusBucket != "" {
+		if err := p.publishStatus(); err != nil {
+			logrus.WithError(err).Warn("failed to publish chart status")
+		}
+	}
+	if p.CallbackURL != "" {
+		if err := p.callback(started, duration, execErr); err != nil {
+			logrus.WithError(err).Warn("failed to call callback url")
+		}
+	}
+}
+
+// fact is a titled detail of a notification.
+type fact struct {
+	title string
+	value string
+}
+
+// facts returns the details included in every notification.
+func (p Plugin) facts(duration time.Duration) []fact {
+	return []fact{
+		{"Chart", p.Package},
+		{"Version", p.ChartVersion},
+		{"Cluster", p.Cluster},
+		{"Namespace", p.Namespace},
+		{"Release", p.Release},
+		{"Duration", duration.Round(time.Second).String()},
+		{"Build", fmt.Sprintf("%s #%s", p.Build.Repo, p.Build.Number)},
+		{"Commit", p.Build.shortCommit()},
+	}
+}
+
+// summary returns a one line description of the run.
+func (p Plugin) summary(execErr error) string {
+	status := "succeeded"
+	if execErr != nil {
+		status = "failed"
+	}
+	return fmt.Sprintf("%s of %s %s to %s/%s %s",
+		strings.Join(p.Actions, ", "), p.Package, p.ChartVersion, p.Cluster, p.Namespace, status)
+}
+
+// notifySlack posts the result to a Slack incoming webhook.
+func (p Plugin) notifySlack(duration time.Duration, execErr error) error {
+	color := "good"
+	text := ""
+	if execErr != nil {
+		color = "danger"
+		text = execErr.Error()
+	}
+
+	fields := make([]interface{}, 0)
+	for _, f := range p.facts(duration) {
+		fields = append(fields, map[string]interface{}{"title": f.title, "value": f.value, "short": true})
+	}
+
+	return postJSON(p.SlackWebhook, nil, map[string]interface{}{
+		"channel":  p.SlackChannel,
+		"username": "drone-gcloud-helm",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"color":      color,
+				"fallback":   p.summary(execErr),
+				"title":      p.summary(execErr),
+				"title_link": p.Build.Link,
+				"text":       text,
+				"fields":     fields,
+			},
+		},
+	})
+}
+
+// notifyTeams posts the result as adaptive card to a Microsoft Teams
+// incoming webhook.
+func (p Plugin) notifyTeams(duration time.Duration, execErr error) error {
+	color := "Good"
+	if execErr != nil {
+		color = "Attention"
+	}
+
+	facts := make([]interface{}, 0)
+	for _, f := range p.facts(duration) {
+		facts = append(facts, map[string]string{"title": f.title, "value": f.value})
+	}
+	body := []interface{}{
+		map[string]interface{}{
+			"type":   "TextBlock",
+			"text":   p.summary(execErr),
+			"weight": "Bolder",
+			"color":  color,
+			"wrap":   true,
+		},
+		map[string]interface{}{"type": "FactSet", "facts": facts},
+	}
+	if execErr != nil {
+		body = append(body, map[string]interface{}{
+			"type": "TextBlock",
+			"text": execErr.Error(),
+			"wrap": true,
+		})
+	}
+	actions := make([]interface{}, 0)
+	if p.Build.Link != "" {
+		actions = append(actions, map[string]string{
+			"type":  "Action.OpenUrl",
+			"title": "Open build",
+			"url":   p.Build.Link,
+		})
+	}
+
+	return postJSON(p.TeamsWebhook, nil, map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+					"actions": actions,
+				},
+			},
+		},
+	})
+}
+
+// discordMaxContent is the maximum message length accepted by Discord.
+const discordMaxContent = 2000
+
+// notifyDiscord posts the result to a Discord webhook. The message content
+// is rendered from DiscordTemplate, on failure DiscordMention is prepended.
+func (p Plugin) notifyDiscord(duration time.Duration, execErr error) error {
+	tmpl, err := template.New("discord").Parse(p.DiscordTemplate)
+	if err != nil {
+		return err
+	}
+	status, errMsg := "success", ""
+	if execErr != nil {
+		status, errMsg = "failure", execErr.Error()
+	}
+	var content bytes.Buffer
+	if err := tmpl.Execute(&content, map[string]interface{}{
+		"Summary":   p.summary(execErr),
+		"Status":    status,
+		"Error":     errMsg,
+		"Chart":     p.Package,
+		"Version":   p.ChartVersion,
+		"Cluster":   p.Cluster,
+		"Namespace": p.Namespace,
+		"Release":   p.Release,
+		"Duration":  duration.Round(time.Second).String(),
+		"Build":     p.Build,
+	}); err != nil {
+		return err
+	}
+	message := content.String()
+	if execErr != nil && p.DiscordMention != "" {
+		message = p.DiscordMention + " " + message
+	}
+	if len(message) > discordMaxContent {
+		message = message[:discordMaxContent]
+	}
+
+	color := 0x2eb886
+	if execErr != nil {
+		color = 0xa30200
+	}
+	fields := make([]interface{}, 0)
+	for _, f := range p.facts(duration) {
+		fields = append(fields, map[string]interface{}{"name": f.title, "value": f.value, "inline": true})
+	}
+
+	return postJSON(p.DiscordWebhook, nil, map[string]interface{}{
+		"username": "drone-gcloud-helm",
+		"content":  message,
+		"embeds": []interface{}{
+			map[string]interface{}{
+				"title":  p.summary(execErr),
+				"url":    p.Build.Link,
+				"color":  color,
+				"fields": fields,
+			},
+		},
+	})
+}
+
+const newrelicDeploymentMutation = `mutation($deployment: ChangeTrackingDeploymentInput!) {
+  changeTrackingCreateDeployment(deployment: $deployment) { deploymentId }
+}`
+
+// newrelicMarker records a change tracking deployment for the configured
+// New Relic entity.
+func (p Plugin) newrelicMarker() error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": newrelicDeploymentMutation,
+		"variables": map[string]interface{}{
+			"deployment": map[string]string{
+				"entityGuid":  p.NewrelicEntityGUID,
+				"version":     p.ChartVersion,
+				"commit":      p.Build.Commit,
+				"user":        p.Build.Author,
+				"deepLink":    p.Build.Link,
+				"description": fmt.Sprintf("%s deployed to %s/%s", p.Release, p.Cluster, p.Namespace),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := sendJSON("POST", p.NewrelicAPI, map[string]string{
+		"API-Key": p.NewrelicAPIKey,
+	}, body, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return errors.New(resp.Errors[0].Message)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// ociRepository returns OCIRegistry as oci:// reference of the repository.
+func (p Plugin) ociRepository() string {
+	return "oci://" + strings.TrimSuffix(strings.TrimPrefix(p.OCIRegistry, "oci://"), "/")
+}
+
+// ociHost returns the host of OCIRegistry, e.g. europe-docker.pkg.dev.
+func (p Plugin) ociHost() string {
+	return strings.SplitN(strings.TrimPrefix(p.ociRepository(), "oci://"), "/", 2)[0]
+}
+
+// ociPushCommand returns the command pushing the package to OCIRegistry.
+// helm push $PACKAGE-$PLUGIN_CHART_VERSION.tgz oci://$PLUGIN_OCI_REGISTRY
+func (p Plugin) ociPushCommand() *exec.Cmd {
+	return exec.Command(p.helm(), "push",
+		fmt.Sprintf("%s-%s.tgz", p.Package, p.ChartVersion),
+		p.ociRepository(),
+	)
+}
+
+// ociChartExists reports whether the chart version was pushed to
+// OCIRegistry.
+// helm show chart oci://$PLUGIN_OCI_REGISTRY/$PACKAGE --version $PLUGIN_CHART_VERSION
+func (p Plugin) ociChartExists() (bool, error) {
+	if err := p.registryLogin(); err != nil {
+		return false, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.helm(), "show", "chart",
+		p.ociRepository()+"/"+p.Package,
+		"--version", p.ChartVersion,
+	)
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = &stderr
+	if err := p.run(cmd); err != nil {
+		if strings.Contains(stderr.String(), "not found") || strings.Contains(stderr.String(), "manifest unknown") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// registryLogin logs helm in to the registry of OCIRegistry with the auth
+// key or, without one or when impersonating, an access token of the active
+// account, once per run. The secret is passed on stdin so it doesn't show up in the command
+// log.
+// helm registry login $HOST --username _json_key --password-stdin
+func (p Plugin) registryLogin() error {
+	host := p.ociHost()
+	return p.auth.once("registry/"+host, func() error {
+		username, password := "_json_key", p.AuthKey
+		if password == "" || p.ImpersonateServiceAccount != "" {
+			token, err := p.accessToken()
+			if err != nil {
+				return fmt.Errorf("no access token to log in to %s: %s", host, err)
+			}
+			p.mask.add(token)
+			username, password = "oauth2accesstoken", token
+		}
+		cmd := exec.Command(p.helm(), "registry", "login", host,
+			"--username", username,
+			"--password-stdin",
+		)
+		cmd.Stdin = strings.NewReader(password)
+		return p.run(cmd)
+	})
+}
+
+// pushOCI pushes the package to OCIRegistry, e.g. an Artifact Registry
+// repository. It needs Helm 3.8 or later.
+func (p Plugin) pushOCI() error {
+	if err := p.registryLogin(); err != nil {
+		return err
+	}
+	return p.run(p.ociPushCommand())
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -17,23 +21,224 @@ import (
 // Plugin defines the Helm plugin parameters.
 type Plugin struct {
 	Debug        bool     `envconfig:"DEBUG"`
+	DryRun       bool     `envconfig:"DRY_RUN"`
+	EnvFile      string   `envconfig:"ENV_FILE"`
 	ShowEnv      bool     `envconfig:"SHOW_ENV"`
 	Wait         bool     `envconfig:"WAIT"`
 	Recreate     bool     `envconfig:"RECREATE_PODS" default:"false"`
 	WaitTimeout  uint32   `envconfig:"WAIT_TIMEOUT" default:"300"`
-	Actions      []string `envconfig:"ACTIONS" required:"true"`
 	AuthKey      string   `envconfig:"AUTH_KEY"`
 	Zone         string   `envconfig:"ZONE"`
+	Region       string   `envconfig:"REGION"`
 	Cluster      string   `envconfig:"CLUSTER"`
 	Project      string   `envconfig:"PROJECT"`
 	Namespace    string   `envconfig:"NAMESPACE"`
 	ChartRepo    string   `envconfig:"CHART_REPO"`
 	Bucket       string   `envconfig:"BUCKET"`
-	ChartPath    string   `envconfig:"CHART_PATH" required:"true"`
+	OCIRegistry  string   `envconfig:"OCI_REGISTRY"`
+	RemoteChart  string   `envconfig:"REMOTE_CHART"`
+	RemoteRepo   string   `envconfig:"REMOTE_REPO"`
+	ChartPath    string   `envconfig:"CHART_PATH"`
 	ChartVersion string   `envconfig:"CHART_VERSION"`
 	Release      string   `envconfig:"RELEASE"`
 	Package      string   `envconfig:"PACKAGE"`
 	Values       []string `envconfig:"VALUES"`
+	ValuesFiles  []string `envconfig:"VALUES_FILES"`
+	LogFile      string   `envconfig:"LOG_FILE" default:"drone-gcloud-helm.log"`
+	NoColor      bool     `envconfig:"NO_COLOR"`
+	Color        bool     `envconfig:"COLOR"`
+	AllowFailure []string `envconfig:"ALLOW_FAILURE"`
+	OtlpEndpoint string   `envconfig:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OtlpHeaders  string   `envconfig:"OTEL_EXPORTER_OTLP_HEADERS"`
+	CloudLogging bool     `envconfig:"CLOUD_LOGGING"`
+	CloudLogName string   `envconfig:"CLOUD_LOG_NAME" default:"drone-gcloud-helm"`
+	SlackWebhook string   `envconfig:"SLACK_WEBHOOK"`
+	SlackChannel string   `envconfig:"SLACK_CHANNEL"`
+	TeamsWebhook string   `envconfig:"TEAMS_WEBHOOK"`
+
+	// ActionSpecs are the configured actions, Actions the ones whose
+	// conditions match the build
+	ActionSpecs actionSpecs `envconfig:"ACTIONS" required:"true"`
+	Actions     []string    `ignored:"true"`
+
+	DiscordWebhook  string `envconfig:"DISCORD_WEBHOOK"`
+	DiscordTemplate string `envconfig:"DISCORD_TEMPLATE" default:"{{ .Summary }}"`
+	DiscordMention  string `envconfig:"DISCORD_MENTION"`
+
+	CallbackURL    string `envconfig:"CALLBACK_URL"`
+	CallbackSecret string `envconfig:"CALLBACK_SECRET"`
+
+	Environment string `envconfig:"ENVIRONMENT"`
+	GithubToken string `envconfig:"GITHUB_TOKEN"`
+	GithubAPI   string `envconfig:"GITHUB_API"`
+	GitlabToken string `envconfig:"GITLAB_TOKEN"`
+	GitlabAPI   string `envconfig:"GITLAB_API"`
+	GiteaToken  string `envconfig:"GITEA_TOKEN"`
+	GiteaAPI    string `envconfig:"GITEA_API"`
+
+	NewrelicAPIKey     string `envconfig:"NEWRELIC_API_KEY"`
+	NewrelicEntityGUID string `envconfig:"NEWRELIC_ENTITY_GUID"`
+	NewrelicAPI        string `envconfig:"NEWRELIC_API" default:"https://api.newrelic.com/graphql"`
+
+	JiraCloudID         string `envconfig:"JIRA_CLOUD_ID"`
+	JiraClientID        string `envconfig:"JIRA_CLIENT_ID"`
+	JiraClientSecret    string `envconfig:"JIRA_CLIENT_SECRET"`
+	JiraEnvironmentType string `envconfig:"JIRA_ENVIRONMENT_TYPE" default:"unmapped"`
+
+	DiffComment      bool `envconfig:"DIFF_COMMENT"`
+	DiffCommentLimit int  `envconfig:"DIFF_COMMENT_LIMIT" default:"60000"`
+
+	BigqueryProject string `envconfig:"BIGQUERY_PROJECT"`
+	BigqueryDataset string `envconfig:"BIGQUERY_DATASET"`
+	BigqueryTable   string `envconfig:"BIGQUERY_TABLE"`
+	PubsubTopic     string `envconfig:"PUBSUB_TOPIC"`
+	KubeEvent       bool   `envconfig:"KUBE_EVENT"`
+	Metrics         bool   `envconfig:"METRICS"`
+	MetricsPrefix   string `envconfig:"METRICS_PREFIX" default:"custom.googleapis.com/drone_gcloud_helm"`
+	StatusBucket    string `envconfig:"STATUS_BUCKET"`
+	ReleaseNotes    bool   `envconfig:"RELEASE_NOTES"`
+	AnnotateRelease bool   `envconfig:"ANNOTATE_RELEASE"`
+
+	HeartbeatInterval time.Duration `envconfig:"HEARTBEAT_INTERVAL" default:"30s"`
+
+	SecretValues   []string `envconfig:"SECRET_VALUES"`
+	SecretKeys     []string `envconfig:"SECRET_KEYS"`
+	TemplateValues bool     `envconfig:"TEMPLATE_VALUES"`
+	ValuesYAML     string   `envconfig:"VALUES_YAML"`
+
+	VaultAddr     string `envconfig:"VAULT_ADDR"`
+	VaultToken    string `envconfig:"VAULT_TOKEN"`
+	VaultRole     string `envconfig:"VAULT_ROLE"`
+	VaultAuthPath string `envconfig:"VAULT_AUTH_PATH" default:"kubernetes"`
+
+	Targets           targets  `envconfig:"TARGETS"`
+	TargetParallelism int      `envconfig:"TARGET_PARALLELISM" default:"2"`
+	Clusters          targets  `envconfig:"CLUSTERS"`
+	Overlays          overlays `envconfig:"OVERLAYS"`
+	Projects          []string `envconfig:"PROJECTS"`
+
+	KubeAs      string   `envconfig:"KUBE_AS"`
+	KubeAsGroup []string `envconfig:"KUBE_AS_GROUP"`
+
+	NamespaceTemplate string `envconfig:"NAMESPACE_TEMPLATE"`
+	ImageTagValue     string `envconfig:"IMAGE_TAG_VALUE" default:"image.tag"`
+
+	InjectDroneMetadata bool `envconfig:"INJECT_DRONE_METADATA"`
+
+	ChangedOnly bool `envconfig:"CHANGED_ONLY"`
+
+	CacheDir       string `envconfig:"CACHE_DIR"`
+	HelmHome       string `envconfig:"HELM_HOME"`
+	HelmCacheHome  string `envconfig:"HELM_CACHE_HOME"`
+	HelmConfigHome string `envconfig:"HELM_CONFIG_HOME"`
+
+	HTTPSProxy   string `envconfig:"HTTPS_PROXY"`
+	NoProxy      string `envconfig:"NO_PROXY"`
+	KubeProxyURL string `envconfig:"KUBE_PROXY_URL"`
+
+	Helmfile            string `envconfig:"HELMFILE" default:"helmfile.yaml"`
+	HelmfileCommand     string `envconfig:"HELMFILE_COMMAND" default:"apply"`
+	HelmfileEnvironment string `envconfig:"HELMFILE_ENVIRONMENT"`
+
+	KustomizePath     string `envconfig:"KUSTOMIZE_PATH" default:"."`
+	KustomizePrune    bool   `envconfig:"KUSTOMIZE_PRUNE"`
+	KustomizeSelector string `envconfig:"KUSTOMIZE_SELECTOR"`
+
+	HelmVersion  string `envconfig:"HELM_VERSION"`
+	GuardStorage bool   `envconfig:"GUARD_STORAGE"`
+	HelmMigrate  bool   `envconfig:"HELM_MIGRATE"`
+	HistoryMax   int    `envconfig:"HISTORY_MAX"`
+
+	RollbackRevision int  `envconfig:"ROLLBACK_REVISION"`
+	KeepHistory      bool `envconfig:"KEEP_HISTORY"`
+	Atomic           bool `envconfig:"ATOMIC"`
+	RolloutStatus    bool `envconfig:"ROLLOUT_STATUS"`
+
+	AuthKeyBase64 string `envconfig:"AUTH_KEY_BASE64"`
+	AuthKeyPath   string `envconfig:"AUTH_KEY_PATH"`
+	AuthMode      string `envconfig:"AUTH_MODE" default:"key"`
+
+	ImpersonateServiceAccount string `envconfig:"IMPERSONATE_SERVICE_ACCOUNT"`
+
+	LintValues     bool   `envconfig:"LINT_VALUES"`
+	TemplateOutput string `envconfig:"TEMPLATE_OUTPUT" default:"manifests/"`
+	Tillerless     bool   `envconfig:"TILLERLESS"`
+	FailOnDiff     bool   `envconfig:"FAIL_ON_DIFF"`
+
+	VersionStrategy string `envconfig:"VERSION_STRATEGY"`
+
+	Immutable    bool `envconfig:"IMMUTABLE"`
+	SkipExisting bool `envconfig:"SKIP_EXISTING"`
+
+	Retries      int           `envconfig:"RETRIES" default:"1"`
+	RetryDelay   time.Duration `envconfig:"RETRY_DELAY" default:"10s"`
+	RetryBackoff float64       `envconfig:"RETRY_BACKOFF" default:"2"`
+
+	InCluster bool `envconfig:"IN_CLUSTER"`
+
+	InstallCRDs bool     `envconfig:"INSTALL_CRDS"`
+	WaitCRDs    []string `envconfig:"WAIT_CRDS"`
+
+	HelmDownloadVersion    string `envconfig:"HELM_DOWNLOAD_VERSION"`
+	KubectlDownloadVersion string `envconfig:"KUBECTL_DOWNLOAD_VERSION"`
+
+	HelmExtraArgs   extraArgs `envconfig:"HELM_EXTRA_ARGS"`
+	GcloudExtraArgs []string  `envconfig:"GCLOUD_EXTRA_ARGS"`
+	GsutilExtraArgs []string  `envconfig:"GSUTIL_EXTRA_ARGS"`
+
+	Charts charts `envconfig:"CHARTS"`
+
+	UpdateIndex bool `envconfig:"UPDATE_INDEX"`
+
+	SmokeTestImage   string   `envconfig:"SMOKE_TEST_IMAGE"`
+	SmokeTestCommand []string `envconfig:"SMOKE_TEST_COMMAND"`
+	SmokeTestEnv     []string `envconfig:"SMOKE_TEST_ENV"`
+
+	VerifyService string   `envconfig:"VERIFY_SERVICE"`
+	VerifyPort    int      `envconfig:"VERIFY_PORT" default:"80"`
+	VerifyChecks  []string `envconfig:"VERIFY_CHECKS"`
+
+	ScaleTo string `envconfig:"SCALE_TO" default:"0"`
+
+	PreviewName        string   `envconfig:"PREVIEW_NAME" default:"{{ .Release }}-pr-{{ .Build.PullRequest }}"`
+	PreviewValues      []string `envconfig:"PREVIEW_VALUES"`
+	PreviewValuesFiles []string `envconfig:"PREVIEW_VALUES_FILES"`
+
+	CleanupTTL      time.Duration `envconfig:"CLEANUP_TTL" default:"168h"`
+	CleanupBranches bool          `envconfig:"CLEANUP_BRANCHES"`
+
+	ValuesDiff  bool   `envconfig:"VALUES_DIFF"`
+	AuditBucket string `envconfig:"AUDIT_BUCKET"`
+
+	DriftFail bool `envconfig:"DRIFT_FAIL"`
+
+	ResourceCheck bool `envconfig:"RESOURCE_CHECK"`
+
+	CostEstimate          bool    `envconfig:"COST_ESTIMATE"`
+	CostThreshold         float64 `envconfig:"COST_THRESHOLD"`
+	CostCPUHour           float64 `envconfig:"COST_CPU_HOUR" default:"0.031611"`
+	CostMemoryGBHour      float64 `envconfig:"COST_MEMORY_GB_HOUR" default:"0.004237"`
+	CostStorageGBMonth    float64 `envconfig:"COST_STORAGE_GB_MONTH" default:"0.04"`
+	CostLoadBalancerMonth float64 `envconfig:"COST_LOAD_BALANCER_MONTH" default:"18.25"`
+
+	Build Build `ignored:"true"`
+
+	// target is the name of the target the plugin copy deploys to, env
+	// holds its additional command environment
+	target string
+	env    []string
+
+	// helmPath and kubectlPath are the selected or downloaded clients
+	helmPath    string
+	kubectlPath string
+
+	cmdLog io.Writer
+	tracer *tracer
+	report *report
+	mask   *mask
+
+	auth   *authCache
+	runner Runner
 }
 
 const (
@@ -42,90 +247,304 @@ const (
 	kubectlBin = "/opt/google-cloud-sdk/bin/kubectl"
 	helmBin    = "/opt/google-cloud-sdk/bin/helm"
 
+	helmfileBin  = "/opt/google-cloud-sdk/bin/helmfile"
+	kustomizeBin = "/opt/google-cloud-sdk/bin/kustomize"
+
 	lintPkg   = "lint"
 	createPkg = "create"
 	pushPkg   = "push"
 	pullPkg   = "pull"
 	deployPkg = "deploy"
 	deletePkg = "delete"
+	diffPkg   = "diff"
+
+	helmfilePkg  = "helmfile"
+	kustomizePkg = "kustomize"
+
+	repoGCPkg    = "repo-gc"
+	smokeTestPkg = "smoke-test"
+	scalePkg     = "scale"
+
+	previewDeployPkg  = "preview-deploy"
+	previewDestroyPkg = "preview-destroy"
+	cleanupPkg        = "cleanup"
+	driftPkg          = "drift"
+
+	rollbackPkg = "rollback"
+	templatePkg = "template"
+)
+
+// noColorEnv disables colored output of the invoked tools.
+var noColorEnv = []string{
+	"NO_COLOR=1",
+	"CLOUDSDK_CORE_DISABLE_COLOR=true",
+	"HELM_DIFF_COLOR=false",
+}
+
+// exit codes of the failure categories, see exitError
+const (
+	exitAuth    = 2
+	exitPackage = 3
+	exitPush    = 4
+	exitDeploy  = 5
 )
 
+var actionExitCodes = map[string]int{
+	lintPkg:   exitPackage,
+	createPkg: exitPackage,
+	pushPkg:   exitPush,
+	pullPkg:   exitPush,
+	deployPkg: exitDeploy,
+	deletePkg: exitDeploy,
+	diffPkg:   exitDeploy,
+
+	helmfilePkg:  exitDeploy,
+	kustomizePkg: exitDeploy,
+
+	repoGCPkg:    exitPush,
+	smokeTestPkg: exitDeploy,
+	scalePkg:     exitDeploy,
+
+	previewDeployPkg:  exitDeploy,
+	previewDestroyPkg: exitDeploy,
+	cleanupPkg:        exitDeploy,
+	driftPkg:          exitDeploy,
+
+	rollbackPkg: exitDeploy,
+	templatePkg: exitPackage,
+}
+
+// exitError tags an error with the exit code of its failure category so
+// downstream automation can tell auth, package, push and deploy failures
+// apart.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+// exitCode returns the process exit code for err.
+func exitCode(err error) int {
+	if e, ok := err.(exitError); ok {
+		return e.code
+	}
+	return 1
+}
+
 var reVersions = regexp.MustCompile(`(?P<realm>Client|Server): &version.Version.SemVer:"(?P<semver>.*?)".*?GitCommit:"(?P<commit>.*?)".*?GitTreeState:"(?P<treestate>.*?)"`)
 
 // Exec executes the plugin step.
-func (p Plugin) Exec() error {
+func (p Plugin) Exec() (err error) {
+	root := p.tracer.start("drone-gcloud-helm", map[string]string{
+		"helm.release":   p.Release,
+		"helm.chart":     p.Package,
+		"helm.version":   p.ChartVersion,
+		"gcp.project":    p.Project,
+		"k8s.cluster":    p.Cluster,
+		"k8s.namespace":  p.Namespace,
+		"plugin.actions": strings.Join(p.Actions, ","),
+	})
+	defer func() { p.tracer.finish(root, err) }()
 
 	// only setup project when needed args are provided
-	if p.Project != "" && p.Cluster != "" && p.AuthKey != "" {
-		if err := p.setupProject(); err != nil {
+	var ts []Plugin
+	if len(p.Targets) > 0 {
+		if err := p.retry("setup", func() (err error) {
+			ts, err = p.setupTargets()
 			return err
+		}); err != nil {
+			return exitError{exitAuth, err}
 		}
-
-		if err := p.helmInit(); err != nil {
-			return err
+	} else if p.Project != "" && p.authenticated() && (p.Cluster != "" || !p.needsCluster()) {
+		if err := p.retry("setup", p.setup); err != nil {
+			return exitError{exitAuth, err}
+		}
+	} else if p.InCluster && p.needsCluster() {
+		if err := p.retry("setup", p.setupInCluster); err != nil {
+			return exitError{exitAuth, err}
 		}
 	}
 
+	if err := p.resolveEnvSecrets(); err != nil {
+		return err
+	}
+
+	p.report.reset()
+	if len(p.Charts) > 0 {
+		return p.execCharts(ts)
+	}
+	return p.execActions(ts)
+}
+
+// execActions executes the actions, the cluster actions on each of the
+// targets ts if any.
+func (p Plugin) execActions(ts []Plugin) error {
 	for _, a := range p.Actions {
-		switch a {
-		case lintPkg:
-			if err := p.lintPackage(); err != nil {
-				return err
-			}
-		case createPkg:
-			if err := p.createPackage(); err != nil {
-				return err
-			}
-		case pushPkg:
-			if err := p.pushPackage(); err != nil {
-				return err
-			}
-		case pullPkg:
-			if err := p.pullPackage(); err != nil {
-				return err
-			}
-		case deployPkg:
-			if err := p.deployPackage(); err != nil {
-				return err
-			}
-		case deletePkg:
-			if err := p.deletePackage(); err != nil {
-				return err
-			}
-		default:
+		if _, ok := actionExitCodes[a]; !ok {
 			return errors.New("unknown action: " + a)
 		}
+		var err error
+		if len(ts) > 0 && clusterActions[a] {
+			err = p.runTargets(a, ts)
+		} else {
+			err = p.runAction(a)
+		}
+		if err == nil {
+			continue
+		}
+		if p.allowFailure(a) {
+			logrus.WithError(err).Warnf("action %s failed, continuing", a)
+			continue
+		}
+		return exitError{actionExitCodes[a], err}
 	}
 
 	return nil
 }
 
+// runAction executes action a and records its result.
+func (p Plugin) runAction(a string) error {
+	if p.DryRun && !dryRunActions[a] {
+		logrus.WithField("action", a).Info("dry run, skipping action")
+		return nil
+	}
+	var attrs map[string]string
+	if p.target != "" {
+		attrs = map[string]string{"plugin.target": p.target}
+	}
+	s := p.tracer.start(a, attrs)
+	started := time.Now()
+	err := p.retry("action "+a, func() error { return p.execAction(a) })
+	p.tracer.finish(s, err)
+	result := p.report.record(a, p.target, started, err)
+	if p.PubsubTopic != "" {
+		if err := p.publishEvent(result); err != nil {
+			logrus.WithError(err).Warn("failed to publish event")
+		}
+	}
+	return err
+}
+
+// execAction executes action a.
+func (p Plugin) execAction(a string) (err error) {
+	switch a {
+	case lintPkg:
+		err = p.lintPackage()
+	case createPkg:
+		err = p.createPackage()
+	case pushPkg:
+		err = p.pushPackage()
+	case pullPkg:
+		err = p.pullPackage()
+	case deployPkg:
+		err = p.deployPackage()
+	case deletePkg:
+		err = p.deletePackage()
+	case diffPkg:
+		err = p.diffPackage()
+	case helmfilePkg:
+		err = p.helmfile()
+	case kustomizePkg:
+		err = p.kustomize()
+	case repoGCPkg:
+		err = p.repoGC()
+	case smokeTestPkg:
+		err = p.smokeTest()
+	case scalePkg:
+		err = p.scale()
+	case previewDeployPkg:
+		err = p.previewDeploy()
+	case previewDestroyPkg:
+		err = p.previewDestroy()
+	case cleanupPkg:
+		err = p.cleanup()
+	case driftPkg:
+		err = p.drift()
+	case rollbackPkg:
+		err = p.rollback()
+	case templatePkg:
+		err = p.template()
+	}
+	return err
+}
+
+// setup authenticates against the project and, when an action needs the
+// cluster, fetches its credentials and prepares helm.
+func (p Plugin) setup() (err error) {
+	s := p.tracer.start("setup", nil)
+	defer func() { p.tracer.finish(s, err) }()
+
+	if err := p.setupProject(); err != nil {
+		return err
+	}
+	if !p.needsCluster() {
+		logrus.Info("no cluster actions, skipping cluster credentials")
+		return nil
+	}
+	if err := p.getCredentials(); err != nil {
+		return err
+	}
+	return p.helmInit()
+}
+
+// allowFailure reports whether action may fail without failing the step.
+func (p Plugin) allowFailure(action string) bool {
+	return contains(p.AllowFailure, action)
+}
+
+// helmArgs returns the extra helm arguments of action.
+func (p Plugin) helmArgs(action string) []string {
+	return p.HelmExtraArgs[action]
+}
+
+// helmShellArgs returns the extra helm arguments of action quoted for
+// /bin/sh, each prefixed with a space.
+func (p Plugin) helmShellArgs(action string) string {
+	var s string
+	for _, a := range p.helmArgs(action) {
+		s += " " + shellQuote(a)
+	}
+	return s
+}
+
+// hasAction reports whether action is part of the configured actions.
+func (p Plugin) hasAction(action string) bool {
+	return contains(p.Actions, action)
+}
+
 // createPackage creates Helm package for Kubernetes.
 // helm package --version $PLUGIN_CHART_VERSION $PLUGIN_CHART_PATH
 func (p Plugin) createPackage() error {
-	cmd := exec.Command(helmBin, "package",
+	if p.ReleaseNotes {
+		restore, err := p.annotateChart()
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+	if p.CacheDir != "" {
+		if err := p.buildDependencies(); err != nil {
+			return err
+		}
+	}
+
+	args := append([]string{"package",
 		"--version",
 		p.ChartVersion,
 		p.ChartPath,
-	)
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-	return cmd.Run()
+	}, p.helmArgs(createPkg)...)
+	cmd := exec.Command(p.helm(), args...)
+	return p.run(cmd)
 }
 
 // cpPackage copies a file from SOURCE to DEST
 // gsutil cp SOURCE DEST
 func (p Plugin) cpPackage(source string, dest string) error {
 	cmd := exec.Command(gsutilBin, "cp", source, dest)
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-	if err := cmd.Run(); err != nil {
+	if err := p.run(cmd); err != nil {
 		return err
 	}
 	return nil
@@ -140,30 +559,73 @@ func (p Plugin) pullPackage() error {
 	)
 }
 
-// pushPackage pushes Helm package to the Google Storage.
+// pushPackage pushes Helm package to the Google Storage and, if set, the
+// OCI registry.
 // gsutil cp $PACKAGE-$PLUGIN_CHART_VERSION.tgz gs://$PLUGIN_BUCKET
 func (p Plugin) pushPackage() error {
-	return p.cpPackage(
+	if p.DryRun {
+		if p.OCIRegistry != "" {
+			p.printCommand(p.ociPushCommand())
+		}
+		if p.Bucket != "" {
+			p.printCommand(exec.Command(gsutilBin, "cp",
+				fmt.Sprintf("%s-%s.tgz", p.Package, p.ChartVersion),
+				fmt.Sprintf("gs://%s", p.Bucket),
+			))
+		}
+		return nil
+	}
+	// checked before any push, so an immutable version isn't pushed to the
+	// other repository either
+	if published, err := p.publishedPackage(); err != nil || published {
+		return err
+	}
+	if p.OCIRegistry != "" {
+		if err := p.pushOCI(); err != nil {
+			return err
+		}
+		if p.Bucket == "" {
+			return nil
+		}
+	}
+	if err := p.cpPackage(
 		fmt.Sprintf("%s-%s.tgz", p.Package, p.ChartVersion),
 		fmt.Sprintf("gs://%s", p.Bucket),
-	)
+	); err != nil {
+		return err
+	}
+	if p.UpdateIndex {
+		if err := p.updateIndex(); err != nil {
+			return err
+		}
+	}
+	if p.ReleaseNotes {
+		return p.pushReleaseNotes()
+	}
+	return nil
 }
 
 // helm lint $CHARTPATH -i
 func (p Plugin) lintPackage() error {
-	helmcmd := fmt.Sprintf("%s lint %s",
-		helmBin,
+	var values string
+	if p.LintValues {
+		var cleanup func()
+		var err error
+		if values, cleanup, err = p.valuesArgs(); err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+	helmcmd := fmt.Sprintf("%s lint %s%s%s",
+		p.helm(),
 		p.ChartPath,
+		values,
+		p.helmShellArgs(lintPkg),
 	)
 
 	cmd := exec.Command("/bin/sh", "-c", helmcmd)
 	cmd.Env = os.Environ()
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-	return cmd.Run()
+	return p.run(cmd)
 
 }
 
@@ -174,56 +636,240 @@ func (p Plugin) deployPackage() error {
 			return err
 		}
 	}
+	if p.GuardStorage {
+		if err := p.guardStorage(); err != nil {
+			return err
+		}
+	}
+	if p.DryRun && (p.InstallCRDs || len(p.WaitCRDs) > 0) {
+		logrus.Info("dry run, skipping the CRD installation")
+	} else if p.InstallCRDs || len(p.WaitCRDs) > 0 {
+		if err := p.installCRDs(); err != nil {
+			return err
+		}
+	}
+	if p.ResourceCheck {
+		if err := p.checkResources(); err != nil {
+			return err
+		}
+	}
+	if p.CostEstimate {
+		if err := p.reportCost(); err != nil {
+			return err
+		}
+	}
 
-	p.Values = append(p.Values, fmt.Sprintf("namespace=%s", p.Namespace))
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return err
+	}
+	if p.Tillerless {
+		return p.deployTillerless()
+	}
+	chart, err := p.chartArg()
+	if err != nil {
+		return err
+	}
+	values := append(p.plainValues(), fmt.Sprintf("namespace=%s", p.Namespace))
 	doRecreate := ""
 	if p.Recreate {
 		doRecreate = "--recreate-pods"
 	}
 
-	helmcmd := fmt.Sprintf("%s upgrade %s %s-%s.tgz --set %s %s --install --namespace %s",
-		helmBin,
+	helmcmd := fmt.Sprintf("%s upgrade %s %s --set %s %s --install --namespace %s",
+		p.helm(),
 		p.Release,
-		p.Package,
-		p.ChartVersion,
-		strings.Join(p.Values, ","),
+		chart,
+		shellQuote(strings.Join(values, ",")),
 		doRecreate,
 		p.Namespace,
 	)
+	if major == 3 {
+		// Helm 2 creates the namespace of a new release, Helm 3 only when asked
+		helmcmd += " --create-namespace"
+		if p.HistoryMax > 0 {
+			helmcmd = fmt.Sprintf("%s --history-max %d", helmcmd, p.HistoryMax)
+		}
+	}
 
 	if p.Wait {
-		helmcmd = fmt.Sprintf("%s --wait --timeout %d", helmcmd, p.WaitTimeout)
+		helmcmd = fmt.Sprintf("%s --wait --timeout %s", helmcmd, helmTimeout(major, p.WaitTimeout))
+	}
+	if p.Atomic {
+		// --atomic implies --wait
+		helmcmd += " --atomic --cleanup-on-fail"
+		if !p.Wait {
+			helmcmd = fmt.Sprintf("%s --timeout %s", helmcmd, helmTimeout(major, p.WaitTimeout))
+		}
+	}
+	files, cleanup, err := p.valuesFileNames()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	for _, f := range files {
+		helmcmd = fmt.Sprintf("%s -f %s", helmcmd, shellQuote(f))
+	}
+	if p.ValuesDiff {
+		if err := p.printValuesDiff(files); err != nil {
+			return err
+		}
+	}
+	secrets, err := p.secretSetArgs()
+	if err != nil {
+		return err
+	}
+	if secrets != "" {
+		helmcmd = fmt.Sprintf("%s %s", helmcmd, secrets)
+	}
+	helmcmd += p.imageTagArgs() + p.droneMetadataArgs()
+	helmcmd += p.helmShellArgs(deployPkg)
+	if p.DryRun {
+		helmcmd += " --dry-run"
+		p.printCommand(exec.Command("/bin/sh", "-c", helmcmd))
 	}
 
 	cmd := exec.Command("/bin/sh", "-c", helmcmd)
 	cmd.Env = os.Environ()
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	if p.Wait || p.Atomic {
+		stop := p.heartbeat("release " + p.Release)
+		defer stop()
+	}
+	if err := p.run(cmd); err != nil {
+		if p.Atomic && !p.DryRun {
+			p.reportRollback()
+		}
+		return err
+	}
+	if p.DryRun {
+		return nil
+	}
+	if p.RolloutStatus {
+		if err := p.rolloutStatus(); err != nil {
+			return err
+		}
+	}
+	if len(p.VerifyChecks) > 0 {
+		if err := p.verifyRelease(); err != nil {
+			return err
+		}
+	}
+
+	if p.KubeEvent {
+		if err := p.createDeployEvent(); err != nil {
+			logrus.WithError(err).Warn("failed to create deploy event")
+		}
+	}
+	if p.AnnotateRelease {
+		if err := p.annotateRelease(); err != nil {
+			logrus.WithError(err).Warn("failed to annotate release")
+		}
+	}
+	if p.AuditBucket != "" {
+		if err := p.exportManifests(); err != nil {
+			logrus.WithError(err).Warn("failed to export manifests")
+		}
+	}
+	return nil
+}
+
+// imageTagArgs returns the --set-string flag setting ImageTagValue to the
+// tag or else the commit of the build, prefixed with a space. It is empty
+// when Values already set ImageTagValue.
+func (p Plugin) imageTagArgs() string {
+	tag := p.imageTag()
+	if p.ImageTagValue == "" || tag == "" {
+		return ""
+	}
+	if p.setsValue(p.ImageTagValue) {
+		return ""
+	}
+	return " --set-string " + shellQuote(p.ImageTagValue+"="+escapeSetValue(tag))
+}
+
+// imageTag returns the image tag of the build, the tag or the commit.
+func (p Plugin) imageTag() string {
+	if p.Build.Tag != "" {
+		return p.Build.Tag
 	}
-	return cmd.Run()
+	return p.Build.Commit
 }
 
-// helm delete $RELEASE
+// deletePackage deletes the pull request release, and its history unless
+// KeepHistory is set.
 func (p Plugin) deletePackage() error {
 	if !strings.Contains(p.Release, "-pr-") {
 		return errors.New("I will only delete pr releases")
 	}
-	cmd := exec.Command(helmBin, "delete", p.Release)
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	return p.uninstallRelease(p.KeepHistory, p.helmArgs(deletePkg)...)
+}
+
+// uninstallRelease deletes the release, and its history unless keepHistory
+// is set, appending extra to the helm arguments.
+// helm delete --purge $RELEASE
+// helm uninstall $RELEASE --namespace $NAMESPACE
+func (p Plugin) uninstallRelease(keepHistory bool, extra ...string) error {
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return err
 	}
-	return cmd.Run()
+	args := []string{"delete", p.Release}
+	switch {
+	case major == 3:
+		args[0] = "uninstall"
+		if keepHistory {
+			args = append(args, "--keep-history")
+		}
+	case !keepHistory:
+		// Helm 2 keeps the release history and blocks its name without
+		args = append(args, "--purge")
+	}
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return err
+	}
+	args = append(args, namespace...)
+	cmd := exec.Command(p.helm(), append(args, extra...)...)
+	return p.run(cmd)
 }
 
 // setupProject setups gcloud project.
-// gcloud auth activate-service-account --key-file=$KEY_FILE_PATH
 // gcloud config set project $PLUGIN_PROJECT
-// gcloud container clusters get-credentials $PLUGIN_CLUSTER --zone $PLUGIN_ZONE
 func (p Plugin) setupProject() error {
+	if err := p.activateServiceAccount(); err != nil {
+		return err
+	}
+
+	// project configuration
+	return p.auth.once("project/"+p.Project, func() error {
+		cmd := exec.Command(gcloudBin, "config",
+			"set",
+			"project",
+			p.Project,
+		)
+		return p.run(cmd)
+	})
+}
+
+// activateServiceAccount authorizes gcloud with the auth key, once per run.
+// gcloud auth activate-service-account --key-file=$KEY_FILE_PATH
+func (p Plugin) activateServiceAccount() error {
+	activate := p.activateKey
+	if p.AuthMode != authModeKey {
+		activate = p.useAmbientCredentials
+	}
+	return p.auth.once("account", func() error {
+		if err := activate(); err != nil {
+			return err
+		}
+		return p.impersonateAccount()
+	})
+}
+
+func (p Plugin) activateKey() error {
+	if err := p.useGcloudConfig(); err != nil {
+		return err
+	}
 	tmpfile, err := ioutil.TempFile("", "auth-key.json")
 	if err != nil {
 		return err
@@ -236,41 +882,118 @@ func (p Plugin) setupProject() error {
 		return err
 	}
 
-	cmds := make([]*exec.Cmd, 0, 3)
-
-	// authorization
-	cmds = append(cmds, exec.Command(gcloudBin, "auth",
+	cmd := exec.Command(gcloudBin, "auth",
 		"activate-service-account",
 		fmt.Sprintf("--key-file=%s", tmpfile.Name()),
-	))
-	// project configuration
-	cmds = append(cmds, exec.Command(gcloudBin, "config",
-		"set",
-		"project",
-		p.Project,
-	))
-	// cluster configuration
-	cmds = append(cmds, exec.Command(gcloudBin, "container",
+	)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+
+	return os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tmpfile.Name())
+}
+
+// getCredentials configures kubectl for the cluster, once per cluster and
+// kubeconfig.
+// gcloud container clusters get-credentials $PLUGIN_CLUSTER --zone $PLUGIN_ZONE --project $PLUGIN_PROJECT
+// gcloud container clusters get-credentials $PLUGIN_CLUSTER --region $PLUGIN_REGION --project $PLUGIN_PROJECT
+func (p Plugin) getCredentials() error {
+	return p.auth.once("credentials/"+p.clusterKey(), p.fetchCredentials)
+}
+
+func (p Plugin) fetchCredentials() error {
+	location, err := p.locationArgs()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"container",
 		"clusters",
 		"get-credentials",
 		p.Cluster,
-		"--zone",
-		p.Zone,
-	))
-
-	for _, cmd := range cmds {
-		if p.Debug {
-			trace(cmd)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		}
-
-		if err := cmd.Run(); err != nil {
+	}, location...)
+	cmd := exec.Command(gcloudBin, append(args, "--project", p.Project)...)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	if p.KubeProxyURL != "" {
+		if err := p.setKubeProxy(); err != nil {
 			return err
 		}
 	}
+	if p.KubeAs != "" || len(p.KubeAsGroup) > 0 {
+		return p.impersonate()
+	}
+	return nil
+}
 
-	return os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tmpfile.Name())
+// accessToken returns an OAuth2 access token of the active account.
+// gcloud auth print-access-token
+func (p Plugin) accessToken() (string, error) {
+	// not run via p.run, the token must not end up in the command log
+	cmd := exec.Command(gcloudBin, "auth", "print-access-token")
+	p.addExtraArgs(cmd)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := p.exec(cmd); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// releaseRevision returns the current revision of the release.
+// helm history $RELEASE --max 1
+func (p Plugin) releaseRevision() (string, error) {
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(p.helm(), append([]string{"history", p.Release, "--max", "1"}, namespace...)...)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return "", err
+	}
+
+	// the first line is the table header
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) == 0 {
+		return "", errors.New("no release history")
+	}
+	return fields[0], nil
+}
+
+// locationArgs returns the --zone or --region flag locating the cluster.
+func (p Plugin) locationArgs() ([]string, error) {
+	switch {
+	case p.Zone != "" && p.Region != "":
+		return nil, fmt.Errorf("cluster %s: set either zone or region, not both", p.Cluster)
+	case p.Zone != "":
+		return []string{"--zone", p.Zone}, nil
+	case p.Region != "":
+		return []string{"--region", p.Region}, nil
+	}
+	return nil, fmt.Errorf("cluster %s: zone or region is required", p.Cluster)
+}
+
+// setLocation sets the zone or, for a region, the region of the cluster.
+// Zones are named after their region with a suffix, e.g. europe-west1-b.
+func (p *Plugin) setLocation(location string) {
+	p.Zone, p.Region = "", ""
+	if strings.Count(location, "-") > 1 {
+		p.Zone = location
+	} else {
+		p.Region = location
+	}
+}
+
+// helmTimeout formats a --timeout of seconds for helm major version major,
+// Helm 2 takes seconds and Helm 3 a duration.
+func helmTimeout(major int, seconds uint32) string {
+	if major == 3 {
+		return fmt.Sprintf("%ds", seconds)
+	}
+	return strconv.FormatUint(uint64(seconds), 10)
 }
 
 // fetchHelmVersions returns helm and tiller versions as map
@@ -278,11 +1001,11 @@ func (p Plugin) setupProject() error {
 func (p Plugin) fetchHelmVersions() (map[string]map[string]string, error) {
 	var out bytes.Buffer
 	var stderr bytes.Buffer
-	cmd := exec.Command(helmBin, "version")
+	cmd := exec.Command(p.helm(), "version")
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := p.run(cmd); err != nil {
 		return nil, errors.New(stderr.String())
 	}
 
@@ -306,13 +1029,8 @@ func (p Plugin) fetchHelmVersions() (map[string]map[string]string, error) {
 func (p Plugin) pollTiller(retryCount int) error {
 	var pollErr error
 	for ; retryCount >= 0; retryCount-- {
-		pollCmd := exec.Command(helmBin, "version")
-		if p.Debug {
-			trace(pollCmd)
-			pollCmd.Stdout = os.Stdout
-			pollCmd.Stderr = os.Stderr
-		}
-		pollErr = pollCmd.Run()
+		pollCmd := exec.Command(p.helm(), "version")
+		pollErr = p.run(pollCmd)
 		if pollErr == nil {
 			break
 		}
@@ -321,36 +1039,55 @@ func (p Plugin) pollTiller(retryCount int) error {
 	return pollErr
 }
 
-// helmInit inits Triller on Kubernetes cluster.
+// helmInit inits Triller on Kubernetes cluster, once per cluster and
+// kubeconfig.
 // helm init
 func (p Plugin) helmInit() error {
+	return p.auth.once("helm/"+p.clusterKey(), p.initTiller)
+}
+
+func (p Plugin) initTiller() error {
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return err
+	}
+	if major == 3 || p.Tillerless {
+		// Helm 3 and tillerless deploys need no Tiller
+		return nil
+	}
+
+	var history []string
+	if p.HistoryMax > 0 {
+		history = []string{"--history-max", strconv.Itoa(p.HistoryMax)}
+	}
 	ver, err := p.fetchHelmVersions()
 	var cmd *exec.Cmd
+	clientOnly := false
 
 	if err != nil {
 		// assume that Tiller is not installed
 		// other errors will be fetched by helm init
-		cmd = exec.Command(helmBin, "init")
+		cmd = exec.Command(p.helm(), append([]string{"init"}, history...)...)
 	} else {
 		switch strings.Compare(ver["client"]["semver"], ver["server"]["semver"]) {
 		case -1: // client is older than tiller
 			return errors.New("helm client is out of date")
 		case 1: // client is newer than tiller
-			cmd = exec.Command(helmBin, "init", "--upgrade")
+			cmd = exec.Command(p.helm(), append([]string{"init", "--upgrade"}, history...)...)
 			break
 		default: // client and tiller are at the same version
-			cmd = exec.Command(helmBin, "init", "--client-only", "--stable-repo-url", "https://charts.helm.sh/stable")
+			cmd = exec.Command(p.helm(), "init", "--client-only", "--stable-repo-url", "https://charts.helm.sh/stable")
+			clientOnly = true
 			break
 		}
 	}
-
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	if p.DryRun && !clientOnly {
+		// a dry run sets up the client only, Tiller is left as it is
+		logrus.WithField("server", ver["server"]["semver"]).Info("dry run, skipping the installation or upgrade of Tiller")
+		return p.run(exec.Command(p.helm(), "init", "--client-only", "--stable-repo-url", "https://charts.helm.sh/stable"))
 	}
 
-	if err := cmd.Run(); err != nil {
+	if err := p.run(cmd); err != nil {
 		return err
 	}
 
@@ -363,69 +1100,175 @@ func (p Plugin) helmInit() error {
 }
 
 func (p Plugin) addRepo() error {
-	cmd := exec.Command(helmBin,
+	cmd := exec.Command(p.helm(),
 		"repo", "add",
 		p.Bucket, p.ChartRepo,
 	)
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-	return cmd.Run()
+	return p.run(cmd)
 }
 
 func (p Plugin) updateRepo() error {
-	cmd := exec.Command(helmBin,
+	cmd := exec.Command(p.helm(),
 		"repo", "update",
 	)
-	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	return p.run(cmd)
+}
+
+// indexRepo indexes the packages in dir. The entries of the existing index,
+// if given, are merged in, so indexing a new package keeps the previously
+// published charts.
+// helm repo index $DIR --url $PLUGIN_CHART_REPO --merge $EXISTING
+func (p Plugin) indexRepo(dir, existing string) error {
+	args := []string{"repo", "index", dir, "--url", p.ChartRepo}
+	if existing != "" {
+		args = append(args, "--merge", existing)
 	}
-	return cmd.Run()
+	return p.run(exec.Command(p.helm(), args...))
 }
 
-func (p Plugin) indexRepo() error {
-	cmd := exec.Command(helmBin, "repo",
-		"index", p.Bucket,
-		"--url", p.ChartRepo,
-	)
+func (p Plugin) kubeConfig() error {
+	cmd := exec.Command(p.kubectl(), "config", "view")
+	return p.run(cmd)
+}
+
+// run executes cmd. In debug mode the command is traced and its output is
+// streamed to the console. The output is always appended to the command log.
+func (p Plugin) run(cmd *exec.Cmd) error {
+	p.addExtraArgs(cmd)
 	if p.Debug {
-		trace(cmd)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		trace(p.mask.args(cmd.Args))
+		if cmd.Stdout == nil {
+			cmd.Stdout = os.Stdout
+		}
+		if cmd.Stderr == nil {
+			cmd.Stderr = os.Stderr
+		}
+	}
+	if p.NoColor || len(p.env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, p.env...)
 	}
-	return cmd.Run()
+	if p.NoColor {
+		cmd.Env = append(cmd.Env, noColorEnv...)
+	}
+	if p.cmdLog != nil {
+		fmt.Fprintf(p.cmdLog, "$ %s\n", strings.Join(p.mask.args(cmd.Args), " "))
+		cmd.Stdout = teeWriter(cmd.Stdout, p.cmdLog)
+		cmd.Stderr = teeWriter(cmd.Stderr, p.cmdLog)
+	}
+	// the error output explains failures when it is not shown
+	var stderr bytes.Buffer
+	cmd.Stderr = teeWriter(cmd.Stderr, &stderr)
+	name := commandName(cmd.Args)
+	s := p.tracer.start(name, nil)
+	started := time.Now()
+	err := p.exec(cmd)
+	duration := time.Since(started)
+	p.tracer.finish(s, err)
+	p.report.addCommandTime(duration)
+	logrus.WithFields(logrus.Fields{
+		"cmd":      name,
+		"duration": duration.Round(time.Millisecond).String(),
+	}).Info("command finished")
+	if p.cmdLog != nil {
+		if err != nil {
+			fmt.Fprintf(p.cmdLog, "# %s\n", err)
+		}
+		fmt.Fprintf(p.cmdLog, "# took %s\n", duration)
+	}
+	if err != nil {
+		return commandError{
+			name:   name,
+			err:    err,
+			stderr: p.mask.apply(lastLines(stderr.String(), stderrLines)),
+		}
+	}
+	return nil
 }
 
-func (p Plugin) movePkg() error {
-	if err := os.Mkdir(p.Bucket, os.ModeDir); err != nil {
-		return err
+// stderrLines is the number of error output lines kept in a commandError.
+const stderrLines = 20
+
+// commandError is the error of a failed command with the end of its error
+// output.
+type commandError struct {
+	name   string
+	err    error
+	stderr string
+}
+
+func (e commandError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.name, e.err)
+	if e.stderr != "" {
+		msg += ": " + e.stderr
 	}
-	if err := cp(
-		fmt.Sprintf("%s-%s.tgz", p.Package, p.ChartVersion),
-		fmt.Sprintf("%s/%s-%s.tgz", p.Bucket, p.Package, p.ChartVersion),
-	); err != nil {
-		return err
+	return msg
+}
+
+func (e commandError) Unwrap() error {
+	return e.err
+}
+
+// lastLines returns the last n lines of s without surrounding whitespace.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
 	}
-	return nil
+	return strings.Join(lines, "\n")
 }
 
-func (p Plugin) kubeConfig() error {
-	cmd := exec.Command(kubectlBin, "config", "view")
-	trace(cmd)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// addExtraArgs adds the extra gcloud and gsutil arguments to cmd. gsutil
+// expects its global options before the command. The project is passed to
+// every command, as targets of several projects share the gcloud
+// configuration of the run.
+func (p Plugin) addExtraArgs(cmd *exec.Cmd) {
+	switch cmd.Args[0] {
+	case gcloudBin:
+		if p.Project != "" && !hasArg(cmd.Args, "--project") && !hasArg(p.GcloudExtraArgs, "--project") {
+			cmd.Args = append(cmd.Args, "--project", p.Project)
+		}
+		cmd.Args = append(cmd.Args, p.GcloudExtraArgs...)
+		if p.ImpersonateServiceAccount != "" {
+			cmd.Args = append(cmd.Args, "--impersonate-service-account="+p.ImpersonateServiceAccount)
+		}
+	case gsutilBin:
+		args := []string{gsutilBin}
+		if p.Project != "" {
+			args = append(args, "-o", "GSUtil:default_project_id="+p.Project)
+		}
+		args = append(args, p.GsutilExtraArgs...)
+		if p.ImpersonateServiceAccount != "" {
+			args = append(args, "-i", p.ImpersonateServiceAccount)
+		}
+		cmd.Args = append(args, cmd.Args[1:]...)
+	}
+}
 
-	return cmd.Run()
+// hasArg reports whether args contain the flag name.
+func hasArg(args []string, name string) bool {
+	for _, a := range args {
+		if a == name || strings.HasPrefix(a, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// teeWriter duplicates writes to w into log, w may be nil.
+func teeWriter(w, log io.Writer) io.Writer {
+	if w == nil {
+		return log
+	}
+	return io.MultiWriter(w, log)
 }
 
 // trace writes each command to stdout with the command wrapped in an xml
 // tag so that it can be extracted and displayed in the logs.
-func trace(cmd *exec.Cmd) {
-	logrus.WithField("cmd", cmd.Args).Debug("debug")
+func trace(args []string) {
+	logrus.WithField("cmd", args).Debug("debug")
 }
 
 // cp copies file
@@ -448,6 +1291,57 @@ func cp(src, dst string) error {
 	return d.Close()
 }
 
+// postJSON posts payload JSON encoded to url
+func postJSON(url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postBody(url, headers, body)
+}
+
+// postBody posts the JSON body to url
+func postBody(url string, headers map[string]string, body []byte) error {
+	return sendJSON("POST", url, headers, body, nil)
+}
+
+// sendJSON sends the JSON body to url and decodes the response into result
+// unless it is nil.
+func sendJSON(method, url string, headers map[string]string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+// contains reports whether s is an element of list
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
 // scanNamed maps named regex groups to a golang map
 func scanNamed(str string, rg *regexp.Regexp) (map[string]string, error) {
 	result := make(map[string]string)
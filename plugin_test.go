@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	helm2Version = "Client: v2.15.2+g8dce272"
+	helm3Version = "v3.5.4+g1b5edb6"
+)
+
+// testPlugin returns a plugin recording its commands. helm reports
+// version, helm history a release at revision 3.
+func testPlugin(version string) (Plugin, *recordingRunner) {
+	r := &recordingRunner{respond: func(args []string) (string, error) {
+		if args[0] != helmBin || len(args) < 2 {
+			return "", nil
+		}
+		switch args[1] {
+		case "version":
+			return version + "\n", nil
+		case "history":
+			return "REVISION\tUPDATED\tSTATUS\n3\tMon Oct 12 10:00:00 2026\tdeployed\n", nil
+		}
+		return "", nil
+	}}
+	p := Plugin{
+		ChartPath:    "chart/app",
+		Package:      "app",
+		Release:      "app-pr-1",
+		ChartVersion: "1.2.3",
+		Namespace:    "web",
+		Bucket:       "charts",
+		runner:       r,
+		report:       &report{},
+		mask:         &mask{},
+		auth:         &authCache{},
+	}
+	return p, r
+}
+
+// actionCommands returns the recorded commands without the helm version
+// probes.
+func actionCommands(r *recordingRunner) [][]string {
+	var cmds [][]string
+	for _, args := range r.recorded() {
+		if args[0] == helmBin && len(args) > 1 && args[1] == "version" {
+			continue
+		}
+		cmds = append(cmds, args)
+	}
+	return cmds
+}
+
+func shellCommand(cmd string) []string {
+	return []string{"/bin/sh", "-c", cmd}
+}
+
+func TestActionCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		action  string
+		setup   func(p *Plugin)
+		want    [][]string
+	}{
+		{
+			name:    "create",
+			version: helm2Version,
+			action:  createPkg,
+			want: [][]string{
+				{helmBin, "package", "--version", "1.2.3", "chart/app"},
+			},
+		},
+		{
+			name:    "push",
+			version: helm2Version,
+			action:  pushPkg,
+			want: [][]string{
+				{gsutilBin, "cp", "app-1.2.3.tgz", "gs://charts"},
+			},
+		},
+		{
+			name:    "deploy helm 2",
+			version: helm2Version,
+			action:  deployPkg,
+			want: [][]string{
+				shellCommand(helmBin + " upgrade app-pr-1 app-1.2.3.tgz --set 'namespace=web'  --install --namespace web"),
+			},
+		},
+		{
+			name:    "deploy helm 3",
+			version: helm3Version,
+			action:  deployPkg,
+			setup: func(p *Plugin) {
+				p.HistoryMax = 5
+				p.Wait = true
+				p.WaitTimeout = 300
+			},
+			want: [][]string{
+				shellCommand(helmBin + " upgrade app-pr-1 app-1.2.3.tgz --set 'namespace=web'  --install --namespace web" +
+					" --create-namespace --history-max 5 --wait --timeout 300s"),
+			},
+		},
+		{
+			name:    "delete helm 2",
+			version: helm2Version,
+			action:  deletePkg,
+			want: [][]string{
+				{helmBin, "delete", "app-pr-1", "--purge"},
+			},
+		},
+		{
+			name:    "delete helm 3",
+			version: helm3Version,
+			action:  deletePkg,
+			setup:   func(p *Plugin) { p.KeepHistory = true },
+			want: [][]string{
+				{helmBin, "uninstall", "app-pr-1", "--keep-history", "--namespace", "web"},
+			},
+		},
+		{
+			name:    "rollback to the previous revision",
+			version: helm3Version,
+			action:  rollbackPkg,
+			want: [][]string{
+				{helmBin, "history", "app-pr-1", "--max", "1", "--namespace", "web"},
+				{helmBin, "rollback", "app-pr-1", "2", "--namespace", "web"},
+			},
+		},
+		{
+			name:    "rollback to a revision",
+			version: helm2Version,
+			action:  rollbackPkg,
+			setup: func(p *Plugin) {
+				p.RollbackRevision = 1
+				p.Wait = true
+				p.WaitTimeout = 60
+			},
+			want: [][]string{
+				{helmBin, "rollback", "app-pr-1", "1", "--wait", "--timeout", "60"},
+			},
+		},
+		{
+			name:    "lint",
+			version: helm2Version,
+			action:  lintPkg,
+			want: [][]string{
+				shellCommand(helmBin + " lint chart/app"),
+			},
+		},
+		{
+			name:    "template helm 2",
+			version: helm2Version,
+			action:  templatePkg,
+			want: [][]string{
+				shellCommand(helmBin + " template app-1.2.3.tgz --name app-pr-1 --namespace web --set 'namespace=web'"),
+			},
+		},
+		{
+			name:    "template helm 3",
+			version: helm3Version,
+			action:  templatePkg,
+			want: [][]string{
+				shellCommand(helmBin + " template app-pr-1 app-1.2.3.tgz --namespace web --set 'namespace=web'"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, r := testPlugin(tt.version)
+			p.TemplateOutput = filepath.Join(t.TempDir(), "manifests") + "/"
+			if tt.setup != nil {
+				tt.setup(&p)
+			}
+			if err := p.execAction(tt.action); err != nil {
+				t.Fatalf("%s: %s", tt.action, err)
+			}
+			if got := actionCommands(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s commands:\n got %q\nwant %q", tt.action, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRunLeavesTiller(t *testing.T) {
+	for name, server := range map[string]string{
+		"missing":  "",
+		"outdated": `Server: &version.Version{SemVer:"v2.16.1", GitCommit:"bbdfe5e", GitTreeState:"clean"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, r := testPlugin(helm2Version)
+			p.DryRun = true
+			short := r.respond
+			r.respond = func(args []string) (string, error) {
+				if len(args) == 2 && args[1] == "version" {
+					if server == "" {
+						return "", errors.New("could not find tiller")
+					}
+					return `Client: &version.Version{SemVer:"v2.17.0", GitCommit:"a690bad", GitTreeState:"clean"}` + "\n" + server + "\n", nil
+				}
+				return short(args)
+			}
+			if err := p.initTiller(); err != nil {
+				t.Fatalf("init: %s", err)
+			}
+			want := [][]string{{helmBin, "init", "--client-only", "--stable-repo-url", "https://charts.helm.sh/stable"}}
+			if got := actionCommands(r); !reflect.DeepEqual(got, want) {
+				t.Errorf("init commands:\n got %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestImmutableOCIPackage(t *testing.T) {
+	for name, missing := range map[string]bool{"published": false, "new": true} {
+		t.Run(name, func(t *testing.T) {
+			p, r := testPlugin(helm3Version)
+			p.Bucket = ""
+			p.OCIRegistry = "europe-docker.pkg.dev/project/charts"
+			p.AuthKey = "key"
+			p.Immutable = true
+			show := []string{helmBin, "show", "chart", "oci://europe-docker.pkg.dev/project/charts/app", "--version", "1.2.3"}
+			respond := r.respond
+			r.respond = func(args []string) (string, error) {
+				if missing && reflect.DeepEqual(args, show) {
+					return "", errors.New("not found")
+				}
+				return respond(args)
+			}
+
+			err := p.execAction(pushPkg)
+			want := [][]string{
+				{helmBin, "registry", "login", "europe-docker.pkg.dev", "--username", "_json_key", "--password-stdin"},
+				show,
+			}
+			if missing {
+				if err != nil {
+					t.Fatalf("push: %s", err)
+				}
+				want = append(want, []string{helmBin, "push", "app-1.2.3.tgz", "oci://europe-docker.pkg.dev/project/charts"})
+			} else if err == nil || !strings.Contains(err.Error(), "immutable") {
+				t.Fatalf("push: got %v, want the immutable error", err)
+			}
+			if got := actionCommands(r); !reflect.DeepEqual(got, want) {
+				t.Errorf("push commands:\n got %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLookupCluster(t *testing.T) {
+	tests := []struct {
+		list string
+		want string
+	}{
+		{"", "has 0 clusters"},
+		{"\n", "has 0 clusters"},
+		{"prod europe-west1\nstaging europe-west1-b\n", "has 2 clusters"},
+		{"prod europe-west1\n", ""},
+	}
+	for _, tt := range tests {
+		p, r := testPlugin(helm2Version)
+		p.Project = "shop"
+		list := tt.list
+		r.respond = func(args []string) (string, error) { return list, nil }
+		name, location, err := p.lookupCluster()
+		if tt.want == "" {
+			if err != nil || name != "prod" || location != "europe-west1" {
+				t.Errorf("cluster of %q: got %s %s %v, want prod europe-west1", tt.list, name, location, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("cluster of %q: got %v, want %q", tt.list, err, tt.want)
+		}
+	}
+}
+
+func TestDeleteOnlyPullRequestReleases(t *testing.T) {
+	p, r := testPlugin(helm3Version)
+	p.Release = "app"
+	err := p.execAction(deletePkg)
+	if err == nil || !strings.Contains(err.Error(), "pr releases") {
+		t.Fatalf("delete of app: got %v, want the pr release error", err)
+	}
+	if cmds := r.recorded(); len(cmds) != 0 {
+		t.Errorf("delete of app ran %q", cmds)
+	}
+}
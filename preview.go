@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// previewLabel marks the namespaces of preview environments.
+	previewLabel = "drone-gcloud-helm/preview"
+
+	// maxReleaseLength is the maximum length of a Helm release name.
+	maxReleaseLength = 53
+)
+
+// preview returns a copy of p deploying the preview environment of the
+// pull request: release and namespace are named by PreviewName and the
+// preview values are applied on top of the others.
+func (p Plugin) preview() (Plugin, error) {
+	if p.Build.PullRequest == "" {
+		return p, errors.New("preview environments require a pull request build")
+	}
+	name, err := p.renderName("preview", p.PreviewName, maxReleaseLength)
+	if err != nil {
+		return p, err
+	}
+	c := p
+	c.Release = name
+	c.Namespace = name
+	c.Values = append([]string{}, p.Values...)
+	for _, v := range p.PreviewValues {
+		c.Values = append(c.Values, expandEnv(v))
+	}
+	c.ValuesFiles = append([]string{}, p.ValuesFiles...)
+	for _, f := range p.PreviewValuesFiles {
+		c.ValuesFiles = append(c.ValuesFiles, expandEnv(f))
+	}
+	return c, nil
+}
+
+// previewDeploy deploys the preview environment of the pull request into
+// its own namespace, which is labeled as preview and annotated with the
+// build.
+func (p Plugin) previewDeploy() error {
+	c, err := p.preview()
+	if err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"release":   c.Release,
+		"namespace": c.Namespace,
+	}).Info("deploying preview environment")
+	if err := c.recordPreview(); err != nil {
+		return err
+	}
+	return c.deployPackage()
+}
+
+// recordPreview creates the namespace of the preview environment if needed
+// and records the environment in its labels and annotations.
+// kubectl create namespace $NAMESPACE
+// kubectl annotate --overwrite namespace $NAMESPACE key=value...
+func (p Plugin) recordPreview() error {
+	if err := p.ensureNamespace(); err != nil {
+		return err
+	}
+	cmd := exec.Command(p.kubectl(), "label", "--overwrite",
+		"namespace", p.Namespace,
+		previewLabel+"=true",
+	)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+
+	annotations := p.releaseAnnotations()
+	annotations["drone-gcloud-helm/release"] = p.Release
+	annotations["drone-gcloud-helm/branch"] = p.Build.Branch
+	annotations["drone-gcloud-helm/deployed"] = time.Now().UTC().Format(time.RFC3339)
+	args := []string{"annotate", "--overwrite", "namespace", p.Namespace}
+	for k, v := range annotations {
+		args = append(args, fmt.Sprintf("%s=%s", k, v))
+	}
+	return p.run(exec.Command(p.kubectl(), args...))
+}
+
+// previewDestroy deletes the release and the namespace of the preview
+// environment of the pull request.
+func (p Plugin) previewDestroy() error {
+	c, err := p.preview()
+	if err != nil {
+		return err
+	}
+	return c.destroyPreview()
+}
+
+// destroyPreview deletes the release and the namespace. A release which is
+// gone already is skipped, otherwise a failed release deletion keeps the
+// namespace.
+// helm delete --purge $RELEASE
+// helm uninstall $RELEASE --namespace $NAMESPACE
+// kubectl delete namespace $NAMESPACE --ignore-not-found
+func (p Plugin) destroyPreview() error {
+	log := logrus.WithFields(logrus.Fields{
+		"release":   p.Release,
+		"namespace": p.Namespace,
+	})
+	log.Info("destroying preview environment")
+
+	if err := p.uninstallRelease(false); err != nil {
+		var cmdErr commandError
+		if !errors.As(err, &cmdErr) || !strings.Contains(cmdErr.stderr, "not found") {
+			return err
+		}
+		log.Info("preview release not found, deleting the namespace")
+	}
+
+	cmd := exec.Command(p.kubectl(), "delete", "namespace", p.Namespace, "--ignore-not-found")
+	return p.run(cmd)
+}
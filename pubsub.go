@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const pubsubPublishURL = "https://pubsub.googleapis.com/v1/%s:publish"
+
+// publishEvent publishes the result of an action to PubsubTopic.
+func (p Plugin) publishEvent(r actionResult) error {
+	topic := p.PubsubTopic
+	if !strings.HasPrefix(topic, "projects/") {
+		topic = fmt.Sprintf("projects/%s/topics/%s", p.Project, topic)
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"action":           r.Action,
+		"status":           r.Status,
+		"error":            r.Error,
+		"started":          r.Started,
+		"duration_seconds": r.Duration,
+		"project":          p.Project,
+		"cluster":          p.Cluster,
+		"namespace":        p.Namespace,
+		"release":          p.Release,
+		"chart":            p.Package,
+		"chart_version":    p.ChartVersion,
+		"build":            p.Build,
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+	return postJSON(fmt.Sprintf(pubsubPublishURL, topic), map[string]string{
+		"Authorization": "Bearer " + token,
+	}, map[string]interface{}{
+		"messages": []interface{}{
+			map[string]interface{}{
+				"data": base64.StdEncoding.EncodeToString(data),
+				"attributes": map[string]string{
+					"action":    r.Action,
+					"status":    r.Status,
+					"release":   p.Release,
+					"namespace": p.Namespace,
+				},
+			},
+		},
+	})
+}
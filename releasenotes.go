@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// chart annotations written when release notes are enabled
+	commitAnnotation       = "drone-gcloud-helm/commit"
+	releaseNotesAnnotation = "drone-gcloud-helm/release-notes"
+
+	// releaseNotesMaxCommits limits the notes when no previous version is found
+	releaseNotesMaxCommits = 50
+)
+
+// repoIndex is the part of a chart repository index.yaml we care about.
+type repoIndex struct {
+	Entries map[string][]struct {
+		Version     string            `yaml:"version"`
+		Created     string            `yaml:"created"`
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"entries"`
+}
+
+// releaseNotesFile returns the name of the release notes sidecar file.
+func (p Plugin) releaseNotesFile() string {
+	return fmt.Sprintf("%s-%s.md", p.Package, p.ChartVersion)
+}
+
+// previousCommit returns the commit the latest published version of the
+// chart was built from, as recorded in the repository index.
+// gsutil cat gs://$PLUGIN_BUCKET/index.yaml
+func (p Plugin) previousCommit() string {
+	if p.Bucket == "" {
+		return ""
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(gsutilBin, "cat", fmt.Sprintf("gs://%s/index.yaml", p.Bucket))
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return ""
+	}
+
+	var index repoIndex
+	if err := yaml.Unmarshal(out.Bytes(), &index); err != nil {
+		return ""
+	}
+	commit, created := "", ""
+	for _, e := range index.Entries[p.Package] {
+		if e.Created > created && e.Annotations[commitAnnotation] != "" {
+			commit, created = e.Annotations[commitAnnotation], e.Created
+		}
+	}
+	return commit
+}
+
+// releaseNotes renders the commit messages since the previous version.
+// git log $PREVIOUS..HEAD --format="- %s (%h)"
+func (p Plugin) releaseNotes() (string, error) {
+	args := []string{"log", "--no-merges", "--format=- %s (%h)"}
+	if prev := p.previousCommit(); prev != "" {
+		args = append(args, prev+"..HEAD")
+	} else {
+		args = append(args, fmt.Sprintf("-n%d", releaseNotesMaxCommits))
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("# %s %s\n\n%s", p.Package, p.ChartVersion, out.String()), nil
+}
+
+// annotateChart writes the release notes to the sidecar file and into the
+// annotations of Chart.yaml. The returned function restores Chart.yaml.
+func (p Plugin) annotateChart() (func(), error) {
+	notes, err := p.releaseNotes()
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(p.releaseNotesFile(), []byte(notes), 0644); err != nil {
+		return nil, err
+	}
+
+	chartFile := filepath.Join(p.ChartPath, "Chart.yaml")
+	orig, err := ioutil.ReadFile(chartFile)
+	if err != nil {
+		return nil, err
+	}
+	var chart yaml.MapSlice
+	if err := yaml.Unmarshal(orig, &chart); err != nil {
+		return nil, err
+	}
+
+	annotations := yaml.MapSlice{}
+	for i, item := range chart {
+		if item.Key == "annotations" {
+			if existing, ok := item.Value.(yaml.MapSlice); ok {
+				annotations = existing
+			}
+			chart = append(chart[:i], chart[i+1:]...)
+			break
+		}
+	}
+	annotations = append(annotations,
+		yaml.MapItem{Key: commitAnnotation, Value: p.Build.Commit},
+		yaml.MapItem{Key: releaseNotesAnnotation, Value: strings.TrimSpace(notes)},
+	)
+	chart = append(chart, yaml.MapItem{Key: "annotations", Value: annotations})
+
+	data, err := yaml.Marshal(chart)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(chartFile, data, 0644); err != nil {
+		return nil, err
+	}
+	return func() {
+		ioutil.WriteFile(chartFile, orig, 0644)
+	}, nil
+}
+
+// pushReleaseNotes uploads the release notes next to the package.
+// gsutil cp $PACKAGE-$PLUGIN_CHART_VERSION.md gs://$PLUGIN_BUCKET
+func (p Plugin) pushReleaseNotes() error {
+	if _, err := os.Stat(p.releaseNotesFile()); err != nil {
+		return nil
+	}
+	return p.cpPackage(p.releaseNotesFile(), fmt.Sprintf("gs://%s", p.Bucket))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// remoteRepoName is the name the remote repository is added to helm as.
+const remoteRepoName = "remote"
+
+// chartArg returns the chart argument of helm upgrade, template and diff,
+// quoted for /bin/sh: the local package or, with RemoteChart, the chart of
+// the remote repository and its version if set. The remote repository is
+// added first.
+func (p Plugin) chartArg() (string, error) {
+	if p.RemoteChart == "" {
+		return fmt.Sprintf("%s-%s.tgz", p.Package, p.ChartVersion), nil
+	}
+	if err := p.addRemoteRepo(); err != nil {
+		return "", err
+	}
+	chart := shellQuote(remoteRepoName + "/" + p.RemoteChart)
+	if p.ChartVersion != "" {
+		chart += " --version " + shellQuote(p.ChartVersion)
+	}
+	return chart, nil
+}
+
+// addRemoteRepo adds RemoteRepo to helm and fetches its index, once per
+// run.
+// helm repo add remote $PLUGIN_REMOTE_REPO
+func (p Plugin) addRemoteRepo() error {
+	if p.RemoteRepo == "" {
+		return fmt.Errorf("remote_repo is required for remote_chart %s", p.RemoteChart)
+	}
+	return p.auth.once("repo/"+p.RemoteRepo, func() error {
+		cmd := exec.Command(p.helm(), "repo", "add", remoteRepoName, p.RemoteRepo)
+		return p.run(cmd)
+	})
+}
+
+// remoteChartName returns the name of RemoteChart without a repository
+// prefix.
+func (p Plugin) remoteChartName() string {
+	s := strings.Split(p.RemoteChart, "/")
+	return s[len(s)-1]
+}
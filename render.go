@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// valuesArgs returns the shell quoted --set, -f and --set-string flags of
+// the values of a deploy, each prefixed with a space. The temporary values
+// files are removed by the returned function.
+func (p Plugin) valuesArgs() (string, func(), error) {
+	values := append(p.plainValues(), fmt.Sprintf("namespace=%s", p.Namespace))
+	args := " --set " + shellQuote(strings.Join(values, ","))
+
+	files, cleanup, err := p.valuesFileArgs()
+	if err != nil {
+		return "", nil, err
+	}
+	if files != "" {
+		args += " " + files
+	}
+	secrets, err := p.secretSetArgs()
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if secrets != "" {
+		args += " " + secrets
+	}
+	return args + p.imageTagArgs() + p.droneMetadataArgs(), cleanup, nil
+}
+
+// renderManifests renders the chart package with the values of the deploy.
+// helm template $PACKAGE-$PLUGIN_CHART_VERSION.tgz --name $RELEASE --namespace $PLUGIN_NAMESPACE
+func (p Plugin) renderManifests() (string, error) {
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return "", err
+	}
+	pkg, err := p.chartArg()
+	if err != nil {
+		return "", err
+	}
+	values, cleanup, err := p.valuesArgs()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	helmcmd := fmt.Sprintf("%s template %s --name %s --namespace %s%s",
+		p.helm(), pkg, p.Release, p.Namespace, values)
+	if major == 3 {
+		helmcmd = fmt.Sprintf("%s template %s %s --namespace %s%s",
+			p.helm(), p.Release, pkg, p.Namespace, values)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", helmcmd)
+	cmd.Env = os.Environ()
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// k8sObject is the part of a rendered Kubernetes object the resource
+// checks care about.
+type k8sObject struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Replicas *int `yaml:"replicas"`
+		// Service
+		Type string `yaml:"type"`
+		// PersistentVolumeClaim
+		Resources containerResources `yaml:"resources"`
+		// StatefulSet
+		VolumeClaimTemplates []struct {
+			Spec struct {
+				Resources containerResources `yaml:"resources"`
+			} `yaml:"spec"`
+		} `yaml:"volumeClaimTemplates"`
+		// workloads
+		Template struct {
+			Spec podSpec `yaml:"spec"`
+		} `yaml:"template"`
+		// Pod
+		Containers []container `yaml:"containers"`
+	} `yaml:"spec"`
+}
+
+type podSpec struct {
+	Containers []container `yaml:"containers"`
+}
+
+type container struct {
+	Resources containerResources `yaml:"resources"`
+}
+
+type containerResources struct {
+	Requests map[string]string `yaml:"requests"`
+}
+
+// parseManifests parses the objects of a multi-document manifest.
+func parseManifests(manifests string) ([]k8sObject, error) {
+	var objects []k8sObject
+	for _, doc := range strings.Split(manifests, "\n---") {
+		var o k8sObject
+		if err := yaml.Unmarshal([]byte(doc), &o); err != nil {
+			return nil, err
+		}
+		if o.Kind != "" {
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// repoGC removes the index entries whose package is missing in the bucket
+// and the packages in the bucket no index entry refers to.
+func (p Plugin) repoGC() error {
+	var orphaned []string
+	err := p.retryIndex(func() (err error) {
+		orphaned, err = p.cleanIndex()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, o := range orphaned {
+		logrus.WithField("package", o).Info("removing orphaned package")
+	}
+	if len(orphaned) > 0 {
+		urls := make([]string, len(orphaned))
+		for i, o := range orphaned {
+			urls[i] = fmt.Sprintf("gs://%s/%s", p.Bucket, o)
+		}
+		cmd := exec.Command(gsutilBin, append([]string{"rm"}, urls...)...)
+		if err := p.run(cmd); err != nil {
+			return err
+		}
+	}
+	logrus.WithField("packages", len(orphaned)).Info("chart repository cleaned")
+	return nil
+}
+
+// cleanIndex removes the entries of packages missing in the bucket from the
+// repository index and returns the packages which are not in the index.
+// gsutil ls gs://$PLUGIN_BUCKET/*.tgz
+func (p Plugin) cleanIndex() ([]string, error) {
+	generation, err := p.indexGeneration()
+	if err != nil {
+		return nil, err
+	}
+	if generation == 0 {
+		return nil, errors.New("no index.yaml in bucket " + p.Bucket)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(gsutilBin, "cat", p.indexURL())
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return nil, err
+	}
+	var index yaml.MapSlice
+	if err := yaml.Unmarshal(out.Bytes(), &index); err != nil {
+		return nil, err
+	}
+
+	out.Reset()
+	cmd = exec.Command(gsutilBin, "ls", fmt.Sprintf("gs://%s/*.tgz", p.Bucket))
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return nil, err
+	}
+	packages := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			packages[path.Base(line)] = true
+		}
+	}
+
+	referenced, removed := pruneEntries(index, packages)
+	var orphaned []string
+	for pkg := range packages {
+		if !referenced[pkg] {
+			orphaned = append(orphaned, pkg)
+		}
+	}
+	sort.Strings(orphaned)
+	if removed == 0 {
+		return orphaned, nil
+	}
+
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "index")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	logrus.WithField("entries", removed).Info("removing dangling index entries")
+	return orphaned, p.uploadIndex(f.Name(), generation)
+}
+
+// pruneEntries removes the chart versions from the entries of index whose
+// packages are all missing and returns the referenced packages and the
+// number of removed versions.
+func pruneEntries(index yaml.MapSlice, packages map[string]bool) (map[string]bool, int) {
+	referenced := make(map[string]bool)
+	removed := 0
+	for i, item := range index {
+		entries, ok := item.Value.(yaml.MapSlice)
+		if item.Key != "entries" || !ok {
+			continue
+		}
+		for j, chart := range entries {
+			versions, _ := chart.Value.([]interface{})
+			kept := make([]interface{}, 0, len(versions))
+			for _, v := range versions {
+				version, _ := v.(yaml.MapSlice)
+				urls := versionURLs(version)
+				found := false
+				for _, u := range urls {
+					if packages[path.Base(u)] {
+						referenced[path.Base(u)] = true
+						found = true
+					}
+				}
+				if found || len(urls) == 0 {
+					kept = append(kept, v)
+					continue
+				}
+				logrus.WithFields(logrus.Fields{
+					"chart":   chart.Key,
+					"version": mapValue(version, "version"),
+				}).Info("removing dangling index entry")
+				removed++
+			}
+			entries[j].Value = kept
+		}
+		index[i].Value = entries
+	}
+	return referenced, removed
+}
+
+// versionURLs returns the package URLs of a chart version of the index.
+func versionURLs(version yaml.MapSlice) []string {
+	list, _ := mapValue(version, "urls").([]interface{})
+	var urls []string
+	for _, u := range list {
+		if s, ok := u.(string); ok {
+			urls = append(urls, s)
+		}
+	}
+	return urls
+}
+
+// mapValue returns the value of key in m.
+func mapValue(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
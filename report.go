@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actionResult is the outcome of a single action.
+type actionResult struct {
+	Action   string    `json:"action"`
+	Target   string    `json:"target,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+}
+
+// report collects the action results of a plugin run. It is safe for
+// concurrent use by the targets.
+type report struct {
+	mu      sync.Mutex
+	actions []actionResult
+	diffs   []string
+	cmdTime time.Duration
+}
+
+// reset drops the results of a previous attempt.
+func (r *report) reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = nil
+	r.diffs = nil
+}
+
+// addCommandTime adds d to the cumulative duration of invoked commands.
+func (r *report) addCommandTime(d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cmdTime += d
+}
+
+// commandTime returns the cumulative duration of invoked commands.
+func (r *report) commandTime() time.Duration {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cmdTime
+}
+
+// addDiff keeps the output of the diff action of target, which is empty
+// when not deploying to multiple targets.
+func (r *report) addDiff(target, diff string) {
+	if r == nil {
+		return
+	}
+	if target != "" {
+		diff = fmt.Sprintf("# target %s\n%s", target, diff)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.diffs = append(r.diffs, diff)
+}
+
+// diff returns the output of the diff actions.
+func (r *report) diff() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.diffs, "\n")
+}
+
+// record adds the result of action on target started at started.
+func (r *report) record(action, target string, started time.Time, err error) actionResult {
+	result := actionResult{
+		Action:   action,
+		Target:   target,
+		Status:   "success",
+		Started:  started.UTC(),
+		Duration: time.Since(started).Seconds(),
+	}
+	if err != nil {
+		result.Status = "failure"
+		result.Error = err.Error()
+	}
+	if r != nil {
+		r.mu.Lock()
+		r.actions = append(r.actions, result)
+		r.mu.Unlock()
+	}
+	return result
+}
+
+// results returns the recorded action results.
+func (r *report) results() []actionResult {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]actionResult{}, r.actions...)
+}
+
+// callback posts a JSON description of the run to CallbackURL. When a
+// CallbackSecret is configured the body is signed with HMAC-SHA256 and the
+// signature is sent in the X-Signature header.
+func (p Plugin) callback(started time.Time, duration time.Duration, execErr error) error {
+	payload := map[string]interface{}{
+		"release":          p.Release,
+		"namespace":        p.Namespace,
+		"project":          p.Project,
+		"cluster":          p.Cluster,
+		"chart":            p.Package,
+		"chart_version":    p.ChartVersion,
+		"status":           "success",
+		"started":          started.UTC(),
+		"duration_seconds": duration.Seconds(),
+		"actions":          p.report.results(),
+		"build":            p.Build,
+	}
+	if execErr != nil {
+		payload["status"] = "failure"
+		payload["error"] = execErr.Error()
+	}
+	if p.hasAction(deployPkg) && execErr == nil {
+		if rev, err := p.releaseRevision(); err == nil {
+			payload["revision"] = rev
+		}
+	}
+	if ver, err := p.fetchHelmVersions(); err == nil {
+		payload["helm_version"] = ver["client"]["semver"]
+		payload["tiller_version"] = ver["server"]["semver"]
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	headers := make(map[string]string)
+	if p.CallbackSecret != "" {
+		mac := hmac.New(sha256.New, []byte(p.CallbackSecret))
+		mac.Write(body)
+		headers["X-Signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return postBody(p.CallbackURL, headers, body)
+}
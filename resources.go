@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// quantitySuffixes are the multipliers of the Kubernetes quantity suffixes.
+var quantitySuffixes = []struct {
+	suffix string
+	factor float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"n", 1e-9}, {"u", 1e-6}, {"m", 1e-3},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+}
+
+// parseQuantity parses a Kubernetes quantity like 500m, 1.5 or 128Mi.
+func parseQuantity(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	for _, q := range quantitySuffixes {
+		if strings.HasSuffix(s, q.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(s, q.suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid quantity %q", s)
+			}
+			return v * q.factor, nil
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return v, nil
+}
+
+// podRequests are the CPU cores and memory bytes requested by the pods of
+// a workload.
+type podRequests struct {
+	name     string
+	replicas int
+	cpu      float64
+	memory   float64
+}
+
+// workloadRequests returns the requests of the pods of the workloads.
+func workloadRequests(objects []k8sObject) ([]podRequests, error) {
+	var workloads []podRequests
+	for _, o := range objects {
+		containers := o.Spec.Template.Spec.Containers
+		replicas := 1
+		switch o.Kind {
+		case "Deployment", "StatefulSet", "ReplicaSet", "ReplicationController":
+			if o.Spec.Replicas != nil {
+				replicas = *o.Spec.Replicas
+			}
+		case "Job", "DaemonSet":
+		case "Pod":
+			containers = o.Spec.Containers
+		default:
+			continue
+		}
+		w := podRequests{name: o.Kind + "/" + o.Metadata.Name, replicas: replicas}
+		for _, c := range containers {
+			for name, dst := range map[string]*float64{"cpu": &w.cpu, "memory": &w.memory} {
+				q, ok := c.Resources.Requests[name]
+				if !ok {
+					continue
+				}
+				v, err := parseQuantity(q)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", w.name, err)
+				}
+				*dst += v
+			}
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+// resourceList is a Kubernetes ResourceList.
+type resourceList map[string]string
+
+// quantity returns the first of the resources present in l.
+func (l resourceList) quantity(names ...string) (float64, bool) {
+	for _, n := range names {
+		if s, ok := l[n]; ok {
+			if v, err := parseQuantity(s); err == nil {
+				return v, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// kubectlJSON runs kubectl get with args and decodes the JSON output into v.
+func (p Plugin) kubectlJSON(v interface{}, args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.Command(p.kubectl(), append(append([]string{"get"}, args...), "-o", "json")...)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	return json.Unmarshal(out.Bytes(), v)
+}
+
+// checkResources renders the chart and fails when the requests of the
+// release exceed the ResourceQuota of the namespace or a pod requests more
+// than the largest node can allocate, as the deploy could never schedule.
+// It warns when the requests exceed the free quota or the allocatable
+// resources of all nodes.
+// kubectl get resourcequota --namespace $PLUGIN_NAMESPACE -o json
+// kubectl get nodes -o json
+func (p Plugin) checkResources() error {
+	manifests, err := p.renderManifests()
+	if err != nil {
+		return err
+	}
+	objects, err := parseManifests(manifests)
+	if err != nil {
+		return err
+	}
+	workloads, err := workloadRequests(objects)
+	if err != nil {
+		return err
+	}
+	var cpu, memory float64
+	for _, w := range workloads {
+		cpu += float64(w.replicas) * w.cpu
+		memory += float64(w.replicas) * w.memory
+	}
+	logrus.WithFields(logrus.Fields{
+		"cpu":    formatCPU(cpu),
+		"memory": formatMemory(memory),
+	}).Info("requests of the release")
+
+	var quotas struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Hard resourceList `json:"hard"`
+				Used resourceList `json:"used"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := p.kubectlJSON(&quotas, "resourcequota", "--namespace", p.Namespace); err != nil {
+		return err
+	}
+	for _, q := range quotas.Items {
+		for _, r := range []struct {
+			name      string
+			requested float64
+			keys      []string
+			format    func(float64) string
+		}{
+			{"cpu", cpu, []string{"requests.cpu", "cpu"}, formatCPU},
+			{"memory", memory, []string{"requests.memory", "memory"}, formatMemory},
+		} {
+			hard, ok := q.Status.Hard.quantity(r.keys...)
+			if !ok {
+				continue
+			}
+			if r.requested > hard {
+				return fmt.Errorf("release requests %s %s, more than the %s of resource quota %s",
+					r.format(r.requested), r.name, r.format(hard), q.Metadata.Name)
+			}
+			used, _ := q.Status.Used.quantity(r.keys...)
+			if r.requested > hard-used {
+				logrus.WithFields(logrus.Fields{
+					"quota":     q.Metadata.Name,
+					"requested": r.format(r.requested),
+					"free":      r.format(hard - used),
+				}).Warnf("%s requests exceed the free quota, the deploy relies on replaced pods", r.name)
+			}
+		}
+	}
+
+	var nodes struct {
+		Items []struct {
+			Status struct {
+				Allocatable resourceList `json:"allocatable"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := p.kubectlJSON(&nodes, "nodes"); err != nil || len(nodes.Items) == 0 {
+		logrus.WithError(err).Warn("failed to list nodes, skipping the node capacity check")
+		return nil
+	}
+	var maxCPU, maxMemory, totalCPU, totalMemory float64
+	for _, n := range nodes.Items {
+		c, _ := n.Status.Allocatable.quantity("cpu")
+		m, _ := n.Status.Allocatable.quantity("memory")
+		maxCPU, maxMemory = math.Max(maxCPU, c), math.Max(maxMemory, m)
+		totalCPU += c
+		totalMemory += m
+	}
+	for _, w := range workloads {
+		if w.cpu > maxCPU || w.memory > maxMemory {
+			return fmt.Errorf("pods of %s request %s cpu and %s memory, more than any node can allocate (%s cpu, %s memory)",
+				w.name, formatCPU(w.cpu), formatMemory(w.memory), formatCPU(maxCPU), formatMemory(maxMemory))
+		}
+	}
+	if cpu > totalCPU || memory > totalMemory {
+		logrus.WithFields(logrus.Fields{
+			"cpu":    formatCPU(totalCPU),
+			"memory": formatMemory(totalMemory),
+		}).Warn("release requests more than all nodes can allocate, the deploy relies on the cluster autoscaler")
+	}
+	return nil
+}
+
+// formatCPU formats cores as millicores.
+func formatCPU(cores float64) string {
+	return fmt.Sprintf("%.0fm", cores*1000)
+}
+
+// formatMemory formats bytes as mebibytes.
+func formatMemory(n float64) string {
+	return fmt.Sprintf("%.0fMi", n/(1<<20))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// backoff returns the delay before retry attempt n, counted from 1:
+// RetryDelay grown by RetryBackoff per retry, with up to 20% jitter so
+// concurrent pipelines don't retry in lockstep.
+func (p Plugin) backoff(n int) time.Duration {
+	backoff := math.Max(p.RetryBackoff, 1)
+	d := float64(p.RetryDelay) * math.Pow(backoff, float64(n-1))
+	return time.Duration(d * (0.8 + 0.4*rand.Float64()))
+}
+
+// retry calls f until it succeeds, at most Retries more times.
+func (p Plugin) retry(what string, f func() error) error {
+	err := f()
+	for n := 1; err != nil && n <= p.Retries; n++ {
+		delay := p.backoff(n)
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"attempt": n,
+			"delay":   delay.Round(time.Millisecond).String(),
+		}).Warnf("%s failed, retrying", what)
+		time.Sleep(delay)
+		err = f()
+	}
+	return err
+}
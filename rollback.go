@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rollback rolls the release back to RollbackRevision or, when not set, to
+// the revision before the current one.
+// helm rollback $RELEASE $REVISION
+func (p Plugin) rollback() error {
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return err
+	}
+	revision := p.RollbackRevision
+	if revision == 0 {
+		current, err := p.releaseRevision()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(current)
+		if err != nil {
+			return fmt.Errorf("invalid revision %q of release %s", current, p.Release)
+		}
+		if n < 2 {
+			return fmt.Errorf("release %s has no previous revision", p.Release)
+		}
+		revision = n - 1
+	}
+
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"rollback", p.Release, strconv.Itoa(revision)}, namespace...)
+	if p.Wait {
+		args = append(args, "--wait", "--timeout", helmTimeout(major, p.WaitTimeout))
+	}
+	cmd := exec.Command(p.helm(), append(args, p.helmArgs(rollbackPkg)...)...)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"release":  p.Release,
+		"revision": revision,
+	}).Info("rolled back release")
+	return nil
+}
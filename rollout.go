@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// rolloutKinds are the kinds kubectl rollout status can follow.
+var rolloutKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+}
+
+// rolloutStatus waits up to WaitTimeout for the rollout of the workloads
+// of the release to complete, failing when one does not, e.g. because its
+// pods are crash looping.
+// helm get manifest $RELEASE
+// kubectl rollout status $KIND/$NAME --namespace $PLUGIN_NAMESPACE --timeout $TIMEOUT
+func (p Plugin) rolloutStatus() error {
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return err
+	}
+	var manifest bytes.Buffer
+	cmd := exec.Command(p.helm(), append([]string{"get", "manifest", p.Release}, namespace...)...)
+	cmd.Stdout = &manifest
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	return p.waitForRollout(manifest.String())
+}
+
+// waitForRollout waits up to WaitTimeout for the rollout of the workloads
+// of the manifests to complete. kubectl follows StatefulSets and DaemonSets
+// and takes --timeout since 1.11.
+func (p Plugin) waitForRollout(manifests string) error {
+	objects, err := parseManifests(manifests)
+	if err != nil {
+		return err
+	}
+
+	stop := p.heartbeat("the rollout of release " + p.Release)
+	defer stop()
+	deadline := time.Now().Add(time.Duration(p.WaitTimeout) * time.Second)
+	for _, o := range objects {
+		if !rolloutKinds[o.Kind] {
+			continue
+		}
+		name := strings.ToLower(o.Kind) + "/" + o.Metadata.Name
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining <= 0 {
+			return fmt.Errorf("rollout of %s not finished after %ds", name, p.WaitTimeout)
+		}
+		cmd := exec.Command(p.kubectl(), "rollout", "status", name,
+			"--namespace", p.Namespace,
+			"--timeout", remaining.String(),
+		)
+		if err := p.run(cmd); err != nil {
+			return fmt.Errorf("rollout of %s: %s", name, err)
+		}
+	}
+	return nil
+}
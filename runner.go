@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// Runner runs the commands of the plugin. All commands go through the
+// Runner of the plugin, so it can be replaced to check or simulate the
+// commands an action builds.
+type Runner interface {
+	Run(cmd *exec.Cmd) error
+}
+
+// execRunner runs commands as processes.
+type execRunner struct{}
+
+func (execRunner) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+// recordingRunner records the arguments of the commands instead of running
+// them. respond, if set, returns the output and error of each command, the
+// error is written to stderr as well.
+type recordingRunner struct {
+	respond func(args []string) (stdout string, err error)
+
+	mu       sync.Mutex
+	commands [][]string
+}
+
+func (r *recordingRunner) Run(cmd *exec.Cmd) error {
+	args := append([]string{}, cmd.Args...)
+	r.mu.Lock()
+	r.commands = append(r.commands, args)
+	r.mu.Unlock()
+	if r.respond == nil {
+		return nil
+	}
+	stdout, err := r.respond(args)
+	if cmd.Stdout != nil {
+		if _, err := io.WriteString(cmd.Stdout, stdout); err != nil {
+			return err
+		}
+	}
+	if err != nil && cmd.Stderr != nil {
+		if _, err := io.WriteString(cmd.Stderr, err.Error()+"\n"); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// recorded returns the arguments of the recorded commands.
+func (r *recordingRunner) recorded() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string{}, r.commands...)
+}
+
+// exec runs cmd with the Runner of p, running it as process when p has
+// none.
+func (p Plugin) exec(cmd *exec.Cmd) error {
+	if p.runner == nil {
+		return cmd.Run()
+	}
+	return p.runner.Run(cmd)
+}
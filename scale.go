@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// replicasAnnotation keeps the replicas of a Deployment scaled by the
+	// scale action, so they can be restored.
+	replicasAnnotation = "drone-gcloud-helm/replicas"
+
+	// scaleRestore is the ScaleTo value restoring the kept replicas.
+	scaleRestore = "restore"
+)
+
+// releaseDeployment is the part of a Deployment we care about.
+type releaseDeployment struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas int `json:"replicas"`
+	} `json:"spec"`
+}
+
+// releaseDeployments returns the Deployments of the release.
+// kubectl get deployments --namespace $PLUGIN_NAMESPACE -l release=$RELEASE -o json
+func (p Plugin) releaseDeployments() ([]releaseDeployment, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(p.kubectl(), "get", "deployments",
+		"--namespace", p.Namespace,
+		"-l", "release="+p.Release,
+		"-o", "json",
+	)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []releaseDeployment `json:"items"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// scale scales the Deployments of the release to ScaleTo replicas, e.g. to
+// park a preview environment. The replicas before are kept in an
+// annotation and restored with ScaleTo restore.
+// kubectl scale deployment $NAME --replicas $REPLICAS --namespace $PLUGIN_NAMESPACE
+func (p Plugin) scale() error {
+	replicas := -1
+	if p.ScaleTo != scaleRestore {
+		n, err := strconv.Atoi(p.ScaleTo)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid scale_to %q, expected replicas or %s", p.ScaleTo, scaleRestore)
+		}
+		replicas = n
+	}
+
+	deployments, err := p.releaseDeployments()
+	if err != nil {
+		return err
+	}
+	for _, d := range deployments {
+		name := d.Metadata.Name
+		target := replicas
+		if replicas < 0 {
+			kept, ok := d.Metadata.Annotations[replicasAnnotation]
+			if !ok {
+				logrus.WithField("deployment", name).Info("no replicas to restore")
+				continue
+			}
+			if target, err = strconv.Atoi(kept); err != nil {
+				return fmt.Errorf("deployment %s: invalid %s annotation %q", name, replicasAnnotation, kept)
+			}
+		} else if _, ok := d.Metadata.Annotations[replicasAnnotation]; !ok && d.Spec.Replicas != replicas {
+			// keep the replicas of the first scale only, repeated scales
+			// must not overwrite them
+			cmd := exec.Command(p.kubectl(), "annotate", "--overwrite",
+				"--namespace", p.Namespace,
+				"deployment/"+name,
+				fmt.Sprintf("%s=%d", replicasAnnotation, d.Spec.Replicas),
+			)
+			if err := p.run(cmd); err != nil {
+				return err
+			}
+		}
+
+		cmd := exec.Command(p.kubectl(), "scale", "deployment", name,
+			"--replicas", strconv.Itoa(target),
+			"--namespace", p.Namespace,
+		)
+		if err := p.run(cmd); err != nil {
+			return err
+		}
+		if replicas < 0 {
+			// kubectl annotate KEY- removes the annotation
+			cmd := exec.Command(p.kubectl(), "annotate",
+				"--namespace", p.Namespace,
+				"deployment/"+name,
+				replicasAnnotation+"-",
+			)
+			if err := p.run(cmd); err != nil {
+				return err
+			}
+		}
+		logrus.WithFields(logrus.Fields{
+			"deployment": name,
+			"from":       d.Spec.Replicas,
+			"to":         target,
+		}).Info("scaled deployment")
+	}
+	return nil
+}
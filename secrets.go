@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	secretManagerAccessURL  = "https://secretmanager.googleapis.com/v1/%s:access"
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// secretSchemes are the prefixes of secret references in values.
+var secretSchemes = []string{"sm://", "vault://", "berglas://"}
+
+// isSecretRef reports whether the value is a secret reference.
+func isSecretRef(value string) bool {
+	for _, s := range secretSchemes {
+		if strings.HasPrefix(value, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// mask hides secret values in logged commands.
+type mask struct {
+	mu     sync.Mutex
+	values []string
+}
+
+// add registers a secret value.
+func (m *mask) add(value string) {
+	if m == nil || value == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values = append(m.values, value)
+}
+
+// apply replaces all registered secret values in s.
+func (m *mask) apply(s string) string {
+	if m == nil {
+		return s
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.values {
+		s = strings.Replace(s, v, "******", -1)
+	}
+	return s
+}
+
+// args returns a copy of args with secret values masked.
+func (m *mask) args(args []string) []string {
+	masked := make([]string, len(args))
+	for i, a := range args {
+		masked[i] = m.apply(a)
+	}
+	return masked
+}
+
+// resolveSecretRef resolves a secret reference such as
+// sm://projects/p/secrets/name/versions/latest, vault://path#key or
+// berglas://bucket/secret to its value.
+func (p Plugin) resolveSecretRef(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "sm://"):
+		return p.accessSecretVersion(strings.TrimPrefix(ref, "sm://"))
+	case strings.HasPrefix(ref, "vault://"):
+		path := strings.SplitN(strings.TrimPrefix(ref, "vault://"), "#", 2)
+		if len(path) != 2 {
+			return "", fmt.Errorf("vault reference needs a key: %s", ref)
+		}
+		return p.vaultSecret(path[0], path[1])
+	case strings.HasPrefix(ref, "berglas://"):
+		// drop the generation and query parameters of the reference
+		ref = strings.SplitN(strings.SplitN(ref, "?", 2)[0], "#", 2)[0]
+		path := strings.SplitN(strings.TrimPrefix(ref, "berglas://"), "/", 2)
+		if len(path) != 2 {
+			return "", fmt.Errorf("berglas reference needs bucket and object: %s", ref)
+		}
+		return p.berglasSecret(path[0], path[1])
+	}
+	return "", fmt.Errorf("unsupported secret reference: %s", ref)
+}
+
+// accessSecretVersion reads a Secret Manager secret version using the
+// active service account.
+func (p Plugin) accessSecretVersion(name string) (string, error) {
+	token, err := p.accessToken()
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := sendJSON("GET", fmt.Sprintf(secretManagerAccessURL, name), map[string]string{
+		"Authorization": "Bearer " + token,
+	}, nil, &resp); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// plainValues returns the Values which are no secret references.
+func (p Plugin) plainValues() []string {
+	values := make([]string, 0, len(p.Values))
+	for _, v := range p.Values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || !isSecretRef(kv[1]) {
+			values = append(values, v)
+		}
+		if len(kv) == 2 && secretKey(kv[0], p.SecretKeys) {
+			p.mask.add(kv[1])
+		}
+	}
+	return values
+}
+
+// secretKey reports whether the chart value key matches one of the glob
+// patterns of secretKeys, e.g. *.password matches db.password.
+func secretKey(key string, secretKeys []string) bool {
+	for _, pattern := range secretKeys {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(key)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// secretValuePrefix is the prefix of environment variables, usually
+// populated from Drone secrets, which are passed as secret chart values.
+const secretValuePrefix = "SECRET_VALUE_"
+
+// envSecretValues returns the SECRET_VALUE_* environment variables as
+// key=value entries. Double underscores in the name separate the levels of
+// the key, e.g. SECRET_VALUE_db__password sets db.password.
+func envSecretValues() []string {
+	var entries []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, secretValuePrefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(e, secretValuePrefix), "=", 2)
+		entries = append(entries, strings.Replace(kv[0], "__", ".", -1)+"="+kv[1])
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// secretSetArgs resolves SecretValues and the secret references in Values
+// and returns them together with the SECRET_VALUE_* variables as shell
+// quoted --set-string flags. The values are masked in all logs.
+func (p Plugin) secretSetArgs() (string, error) {
+	entries := append([]string{}, p.SecretValues...)
+	for _, v := range p.Values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 && isSecretRef(kv[1]) {
+			entries = append(entries, v)
+		}
+	}
+
+	var args []string
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return "", fmt.Errorf("invalid secret value: %s", entry)
+		}
+		value, err := p.resolveSecretRef(kv[1])
+		if err != nil {
+			return "", err
+		}
+		args = append(args, p.secretSetArg(kv[0], value))
+	}
+	for _, entry := range envSecretValues() {
+		kv := strings.SplitN(entry, "=", 2)
+		value := kv[1]
+		if isSecretRef(value) {
+			var err error
+			if value, err = p.resolveSecretRef(value); err != nil {
+				return "", err
+			}
+		}
+		args = append(args, p.secretSetArg(kv[0], value))
+	}
+	return strings.Join(args, " "), nil
+}
+
+// secretSetArg masks value and returns the --set-string flag setting key to
+// it.
+func (p Plugin) secretSetArg(key, value string) string {
+	p.mask.add(value)
+	p.mask.add(escapeSetValue(value))
+	return "--set-string " + shellQuote(key+"="+escapeSetValue(value))
+}
+
+// escapeSetValue escapes the characters helm interprets in --set values.
+func escapeSetValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	return strings.Replace(v, ",", `\,`, -1)
+}
+
+// shellQuote quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// vaultSecret reads key of the secret at path from Vault. KV version 1 and
+// 2 secret engines are supported.
+func (p Plugin) vaultSecret(path, key string) (string, error) {
+	if p.VaultAddr == "" {
+		return "", fmt.Errorf("vault_addr is required to resolve vault://%s", path)
+	}
+	token, err := p.vaultToken()
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := sendJSON("GET", fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(p.VaultAddr, "/"), path),
+		map[string]string{"X-Vault-Token": token}, nil, &resp); err != nil {
+		return "", err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// vaultToken returns VaultToken or logs in with the Kubernetes auth method
+// using the mounted service account token.
+func (p Plugin) vaultToken() (string, error) {
+	if p.VaultToken != "" {
+		return p.VaultToken, nil
+	}
+	if p.VaultRole == "" {
+		return "", fmt.Errorf("vault_token or vault_role is required")
+	}
+	jwt, err := ioutil.ReadFile(serviceAccountTokenFile)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{
+		"role": p.VaultRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := sendJSON("POST", fmt.Sprintf("%s/v1/auth/%s/login", strings.TrimSuffix(p.VaultAddr, "/"), p.VaultAuthPath),
+		nil, body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Auth.ClientToken, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sensitiveNames are parts of environment variable names whose values are
+// redacted by showEnv.
+var sensitiveNames = []string{
+	"KEY",
+	"TOKEN",
+	"SECRET",
+	"PASSWORD",
+	"PASSWD",
+	"CREDENTIAL",
+	"AUTH",
+	"WEBHOOK",
+	"PRIVATE",
+	"CERT",
+}
+
+// normalizeSettings rewrites the PLUGIN_* environment so parameters of
+// Drone 0.8 and Drone 1.x pipelines are parsed alike:
+//
+//   - {"from_secret": "name"} values, which are passed on unresolved by
+//     drone exec, are replaced by the NAME variable, the way Drone 0.8
+//     exposes secrets.
+//   - list parameters given as JSON list or one entry per line are joined
+//     with commas, the way Drone 1.x passes lists.
+func normalizeSettings() error {
+	lists := listSettings(reflect.TypeOf(Plugin{}))
+	for _, e := range os.Environ() {
+		kv := strings.SplitN(e, "=", 2)
+		key, value := kv[0], kv[1]
+		if !strings.HasPrefix(key, "PLUGIN_") {
+			continue
+		}
+
+		normalized := value
+		if name := fromSecret(value); name != "" {
+			normalized = os.Getenv(strings.ToUpper(name))
+		}
+		if lists[key] {
+			normalized = joinList(normalized)
+		}
+		if normalized == value {
+			continue
+		}
+		if err := os.Setenv(key, normalized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnUnknownSettings logs a warning for each PLUGIN_* variable which is
+// no parameter, suggesting the closest parameter name for typos.
+func warnUnknownSettings() {
+	known := settingNames(reflect.TypeOf(Plugin{}))
+	for _, e := range os.Environ() {
+		name := strings.SplitN(e, "=", 2)[0]
+		if !strings.HasPrefix(name, "PLUGIN_") || known[name] {
+			continue
+		}
+		entry := logrus.WithField("variable", name)
+		if s := closestName(name, known); s != "" {
+			entry = entry.WithField("suggestion", s)
+		}
+		entry.Warn("unknown parameter")
+	}
+}
+
+// settingNames returns the PLUGIN_* names of the parameters of t.
+func settingNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("envconfig"); tag != "" {
+			names["PLUGIN_"+tag] = true
+		}
+	}
+	return names
+}
+
+// closestName returns the name of names with the smallest edit distance
+// to name if it is a likely typo.
+func closestName(name string, names map[string]bool) string {
+	best, bestDist := "", 4
+	for n := range names {
+		if d := editDistance(name, n); d < bestDist || d == bestDist && n < best {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// listSettings returns the PLUGIN_* names of the list parameters of t.
+func listSettings(t reflect.Type) map[string]bool {
+	lists := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("envconfig")
+		if tag != "" && f.Type == reflect.TypeOf([]string{}) {
+			lists["PLUGIN_"+tag] = true
+		}
+	}
+	return lists
+}
+
+// fromSecret returns the secret name of a {"from_secret": "name"} value.
+func fromSecret(value string) string {
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return ""
+	}
+	var ref struct {
+		FromSecret string `json:"from_secret"`
+	}
+	if err := json.Unmarshal([]byte(value), &ref); err != nil {
+		return ""
+	}
+	return ref.FromSecret
+}
+
+// joinList converts a JSON list or newline separated list to a comma
+// separated one.
+func joinList(value string) string {
+	var entries []string
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		if err := json.Unmarshal([]byte(value), &entries); err == nil {
+			return strings.Join(entries, ",")
+		}
+	}
+	if !strings.Contains(value, "\n") {
+		return value
+	}
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// showEnv prints the sorted environment as key=value pairs. The values of
+// variables whose names contain a sensitive part or match one of the glob
+// patterns of secretKeys are redacted.
+func showEnv(secretKeys []string) {
+	env := os.Environ()
+	sort.Strings(env)
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if sensitiveEnv(kv[0], secretKeys) && kv[1] != "" {
+			kv[1] = "******"
+		}
+		fmt.Printf("%s=%s\n", kv[0], kv[1])
+	}
+}
+
+// sensitiveEnv reports whether the value of the environment variable name
+// must not be shown.
+func sensitiveEnv(name string, secretKeys []string) bool {
+	upper := strings.ToUpper(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(upper, s) {
+			return true
+		}
+	}
+	for _, pattern := range secretKeys {
+		if ok, _ := path.Match(strings.ToUpper(pattern), upper); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jobPollInterval is the interval in which the smoke test Job is checked
+// for completion.
+const jobPollInterval = 5 * time.Second
+
+// smokeTest runs SmokeTestImage with SmokeTestCommand as Job in the
+// namespace, waits up to WaitTimeout for it to complete, prints its logs
+// and deletes it. It fails when the Job failed.
+// kubectl create -f - --namespace $PLUGIN_NAMESPACE
+func (p Plugin) smokeTest() error {
+	if p.SmokeTestImage == "" {
+		return errors.New("smoke-test requires a smoke_test_image")
+	}
+	name := slugify(fmt.Sprintf("%s-smoke-test-%d", p.Release, time.Now().Unix()), maxLabelLength)
+	env := []map[string]string{}
+	for _, e := range p.SmokeTestEnv {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid smoke_test_env %q, expected NAME=value", e)
+		}
+		env = append(env, map[string]string{"name": kv[0], "value": kv[1]})
+	}
+	job, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": p.Namespace,
+			"labels": map[string]string{
+				"release": p.Release,
+			},
+		},
+		"spec": map[string]interface{}{
+			"backoffLimit": 0,
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": map[string]string{
+						"release": p.Release,
+					},
+				},
+				"spec": map[string]interface{}{
+					"restartPolicy": "Never",
+					"containers": []map[string]interface{}{{
+						"name":    "smoke-test",
+						"image":   p.SmokeTestImage,
+						"command": p.SmokeTestCommand,
+						"env":     env,
+					}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(p.kubectl(), "create", "-f", "-", "--namespace", p.Namespace)
+	cmd.Stdin = bytes.NewReader(job)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	defer func() {
+		cmd := exec.Command(p.kubectl(), "delete", "job", name, "--namespace", p.Namespace)
+		if err := p.run(cmd); err != nil {
+			logrus.WithError(err).WithField("job", name).Warn("failed to delete smoke test job")
+		}
+	}()
+
+	logrus.WithField("job", name).Info("waiting for smoke test")
+	succeeded, err := p.waitForJob(name)
+	if err := p.jobLogs(name); err != nil {
+		logrus.WithError(err).WithField("job", name).Warn("failed to get smoke test logs")
+	}
+	if err != nil {
+		return err
+	}
+	if !succeeded {
+		return fmt.Errorf("smoke test job %s failed", name)
+	}
+	logrus.WithField("job", name).Info("smoke test succeeded")
+	return nil
+}
+
+// waitForJob polls the Job until it succeeded or failed or WaitTimeout
+// expired.
+// kubectl get job $NAME --namespace $PLUGIN_NAMESPACE -o json
+func (p Plugin) waitForJob(name string) (succeeded bool, err error) {
+	stop := p.heartbeat("job " + name)
+	defer stop()
+	deadline := time.Now().Add(time.Duration(p.WaitTimeout) * time.Second)
+	for {
+		var out bytes.Buffer
+		cmd := exec.Command(p.kubectl(), "get", "job", name,
+			"--namespace", p.Namespace,
+			"-o", "json",
+		)
+		cmd.Stdout = &out
+		err := p.run(cmd)
+		if err == nil {
+			var job struct {
+				Status struct {
+					Succeeded int `json:"succeeded"`
+					Failed    int `json:"failed"`
+				} `json:"status"`
+			}
+			if err = json.Unmarshal(out.Bytes(), &job); err == nil {
+				if job.Status.Succeeded > 0 {
+					return true, nil
+				}
+				if job.Status.Failed > 0 {
+					return false, nil
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return false, fmt.Errorf("smoke test job %s: %s", name, err)
+			}
+			return false, fmt.Errorf("smoke test job %s did not complete after %ds", name, p.WaitTimeout)
+		}
+		time.Sleep(jobPollInterval)
+	}
+}
+
+// jobLogs prints the logs of the Job to stdout.
+// kubectl logs job/$NAME --namespace $PLUGIN_NAMESPACE
+func (p Plugin) jobLogs(name string) error {
+	cmd := exec.Command(p.kubectl(), "logs", "job/"+name, "--namespace", p.Namespace)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return p.run(cmd)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var reSopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// sopsMetadata is the part of the sops metadata needed for GCP KMS.
+type sopsMetadata struct {
+	GcpKms []struct {
+		ResourceID string `yaml:"resource_id"`
+		Enc        string `yaml:"enc"`
+	} `yaml:"gcp_kms"`
+}
+
+// isSopsFile reports whether the values file is encrypted with sops.
+func isSopsFile(name string, doc yaml.MapSlice) bool {
+	if strings.HasSuffix(name, ".enc.yaml") || strings.HasSuffix(name, ".enc.yml") {
+		return true
+	}
+	for _, item := range doc {
+		if item.Key == "sops" {
+			return true
+		}
+	}
+	return false
+}
+
+// decryptSopsFile decrypts a sops encrypted values file with the GCP KMS
+// data key and writes the plain values to a temporary file, whose name is
+// returned. The sops MAC is not verified.
+func (p Plugin) decryptSopsFile(name string, doc yaml.MapSlice) (string, error) {
+	var meta sopsMetadata
+	plain := yaml.MapSlice{}
+	for _, item := range doc {
+		if item.Key != "sops" {
+			plain = append(plain, item)
+			continue
+		}
+		raw, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return "", err
+		}
+		if err := yaml.Unmarshal(raw, &meta); err != nil {
+			return "", err
+		}
+	}
+	if len(meta.GcpKms) == 0 {
+		return "", fmt.Errorf("%s has no gcp_kms key", name)
+	}
+
+	key, err := p.sopsDataKey(meta)
+	if err != nil {
+		return "", err
+	}
+	decrypted, err := sopsDecrypt(key, plain, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
+	}
+
+	data, err := yaml.Marshal(decrypted)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "values")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// sopsDataKey decrypts the data key with the first GCP KMS key able to.
+func (p Plugin) sopsDataKey(meta sopsMetadata) ([]byte, error) {
+	token, err := p.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range meta.GcpKms {
+		body, err := json.Marshal(map[string]string{"ciphertext": k.Enc})
+		if err != nil {
+			return nil, err
+		}
+		var resp struct {
+			Plaintext string `json:"plaintext"`
+		}
+		if err = sendJSON("POST", fmt.Sprintf(kmsDecryptURL, k.ResourceID), map[string]string{
+			"Authorization": "Bearer " + token,
+		}, body, &resp); err != nil {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(resp.Plaintext)
+	}
+	return nil, fmt.Errorf("no gcp_kms key could decrypt the data key")
+}
+
+// sopsDecrypt decrypts all sops encrypted values of v. path holds the keys
+// leading to v, which sops uses as additional authenticated data.
+func sopsDecrypt(key []byte, v interface{}, path []string) (interface{}, error) {
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		result := make(yaml.MapSlice, 0, len(t))
+		for _, item := range t {
+			value, err := sopsDecrypt(key, item.Value, append(path, fmt.Sprint(item.Key)))
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, yaml.MapItem{Key: item.Key, Value: value})
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, 0, len(t))
+		for _, item := range t {
+			value, err := sopsDecrypt(key, item, path)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	case string:
+		return sopsDecryptValue(key, t, strings.Join(path, ":")+":")
+	}
+	return v, nil
+}
+
+// sopsDecryptValue decrypts a single ENC[AES256_GCM,...] value.
+func sopsDecryptValue(key []byte, value, aad string) (interface{}, error) {
+	m := reSopsValue.FindStringSubmatch(value)
+	if m == nil {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return nil, err
+	}
+	iv, err := base64.StdEncoding.DecodeString(m[2])
+	if err != nil {
+		return nil, err
+	}
+	tag, err := base64.StdEncoding.DecodeString(m[3])
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s", aad)
+	}
+
+	switch m[4] {
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	}
+	return string(plain), nil
+}
+
+// valuesFileArgs returns the -f flags of ValuesFiles and ValuesYAML.
+// Encrypted files are decrypted and templates are rendered to temporary
+// files, which are removed by the returned function.
+func (p Plugin) valuesFileArgs() (string, func(), error) {
+	files, cleanup, err := p.valuesFileNames()
+	if err != nil {
+		return "", nil, err
+	}
+	args := make([]string, len(files))
+	for i, f := range files {
+		args[i] = "-f " + shellQuote(f)
+	}
+	return strings.Join(args, " "), cleanup, nil
+}
+
+// valuesFileNames returns the names of the files passed to helm for
+// ValuesFiles and ValuesYAML, see valuesFileArgs.
+func (p Plugin) valuesFileNames() ([]string, func(), error) {
+	var files, temp []string
+	cleanup := func() {
+		for _, f := range temp {
+			os.Remove(f)
+		}
+	}
+
+	for _, name := range p.ValuesFiles {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("values_files: %s", err)
+		}
+		var doc yaml.MapSlice
+		// templates are not necessarily valid yaml before rendering
+		yamlErr := yaml.Unmarshal(data, &doc)
+		switch {
+		case yamlErr == nil && isSopsFile(name, doc):
+			decrypted, err := p.decryptSopsFile(name, doc)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			temp = append(temp, decrypted)
+			name = decrypted
+		case p.TemplateValues:
+			rendered, err := p.renderValuesFile(name, data)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			temp = append(temp, rendered)
+			name = rendered
+		case yamlErr != nil:
+			cleanup()
+			return nil, nil, fmt.Errorf("%s: %s", name, yamlErr)
+		}
+		files = append(files, name)
+	}
+
+	// the inline values come last so they override the files, helm deep
+	// merges all files and the --set values on top
+	if p.ValuesYAML != "" {
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal([]byte(p.ValuesYAML), &doc); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("values_yaml: %s", err)
+		}
+		f, err := ioutil.TempFile("", "values")
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		temp = append(temp, f.Name())
+		_, err = f.WriteString(p.ValuesYAML)
+		f.Close()
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		files = append(files, f.Name())
+	}
+	return files, cleanup, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// chartStatus is the state of a chart published to StatusBucket for the
+// developer portal.
+type chartStatus struct {
+	Chart            string    `json:"chart"`
+	PublishedVersion string    `json:"published_version,omitempty"`
+	PublishedAt      time.Time `json:"published_at,omitempty"`
+	DeployStatus     string    `json:"deploy_status,omitempty"`
+	DeployedVersion  string    `json:"deployed_version,omitempty"`
+	DeployedAt       time.Time `json:"deployed_at,omitempty"`
+	Cluster          string    `json:"cluster,omitempty"`
+	Namespace        string    `json:"namespace,omitempty"`
+	BuildLink        string    `json:"build_link,omitempty"`
+}
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[3]d" height="20">
+<rect width="%[4]d" height="20" fill="#555"/>
+<rect x="%[4]d" width="%[5]d" height="20" fill="%[6]s"/>
+<g fill="#fff" font-family="Verdana,sans-serif" font-size="11">
+<text x="6" y="14">%[1]s</text>
+<text x="%[7]d" y="14">%[2]s</text>
+</g>
+</svg>
+`
+
+// statusPath returns the bucket path of the chart status files.
+func (p Plugin) statusPath() string {
+	path := strings.TrimSuffix(p.StatusBucket, "/")
+	if !strings.HasPrefix(path, "gs://") {
+		path = "gs://" + path
+	}
+	return fmt.Sprintf("%s/%s", path, p.Package)
+}
+
+// publishStatus updates status.json and badge.svg of the chart with the
+// results of the push and deploy actions.
+// gsutil cp status.json badge.svg gs://$PLUGIN_STATUS_BUCKET/$PACKAGE/
+func (p Plugin) publishStatus() error {
+	status := chartStatus{Chart: p.Package}
+
+	// keep the fields of the actions not run in this step
+	var out bytes.Buffer
+	cat := exec.Command(gsutilBin, "cat", p.statusPath()+"/status.json")
+	cat.Stdout = &out
+	if err := p.run(cat); err == nil {
+		json.Unmarshal(out.Bytes(), &status)
+	}
+
+	for _, r := range p.report.results() {
+		switch r.Action {
+		case pushPkg:
+			if r.Status == "success" {
+				status.PublishedVersion = p.ChartVersion
+				status.PublishedAt = r.Started
+			}
+		case deployPkg:
+			status.DeployStatus = r.Status
+			status.DeployedVersion = p.ChartVersion
+			status.DeployedAt = r.Started
+			status.Cluster = p.Cluster
+			status.Namespace = p.Namespace
+			status.BuildLink = p.Build.Link
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "chart-status")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dir+"/status.json", data, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dir+"/badge.svg", []byte(badge(status)), 0644); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(gsutilBin, "-h", "Cache-Control:no-cache",
+		"cp", dir+"/status.json", dir+"/badge.svg", p.statusPath()+"/")
+	return p.run(cmd)
+}
+
+// badge renders a badge with the chart name and latest version colored by
+// the last deploy status.
+func badge(s chartStatus) string {
+	version := s.PublishedVersion
+	if version == "" {
+		version = s.DeployedVersion
+	}
+	color := "#9f9f9f"
+	switch s.DeployStatus {
+	case "success":
+		color = "#4c1"
+	case "failure":
+		color = "#e05d44"
+	}
+	// approximate text widths of 7px per character
+	left := len(s.Chart)*7 + 12
+	right := len(version)*7 + 12
+	return fmt.Sprintf(badgeTemplate, s.Chart, version, left+right, left, right, color, left+6)
+}
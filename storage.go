@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// helmMajorVersion returns the major version of the helm client, 2 or 3.
+// helm version --client --short
+func (p Plugin) helmMajorVersion() (int, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(p.helm(), "version", "--client", "--short")
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return 0, err
+	}
+	// Helm 2 prints "Client: v2.15.2+g8dce272", Helm 3 "v3.4.1+gc4e7485"
+	version := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(out.String()), "Client:"))
+	switch {
+	case strings.HasPrefix(version, "v2."):
+		return 2, nil
+	case strings.HasPrefix(version, "v3."):
+		return 3, nil
+	}
+	return 0, fmt.Errorf("unknown helm version: %s", version)
+}
+
+// releaseStored reports whether kubectl finds objects of kind in namespace
+// matching selector.
+// kubectl get $KIND --namespace $NAMESPACE -l $SELECTOR -o name
+func (p Plugin) releaseStored(kind, namespace, selector string) (bool, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(p.kubectl(), "get", kind,
+		"--namespace", namespace,
+		"-l", selector,
+		"-o", "name",
+	)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out.String()) != "", nil
+}
+
+// guardStorage checks that the release is not stored by another major
+// version of helm than the client, which would install a second release
+// of the same name. With HelmMigrate a Helm 2 release is converted for a
+// Helm 3 client using the helm-2to3 plugin, except in dry runs.
+func (p Plugin) guardStorage() error {
+	major, err := p.helmMajorVersion()
+	if err != nil {
+		return err
+	}
+	v2, err := p.releaseStored("configmaps", "kube-system", "OWNER=TILLER,NAME="+p.Release)
+	if err != nil {
+		return err
+	}
+	v3, err := p.releaseStored("secrets", p.Namespace, "owner=helm,name="+p.Release)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v2 && v3:
+		return fmt.Errorf("release %s is stored by Helm 2 and Helm 3, clean up one of them", p.Release)
+	case major == 2 && v3:
+		return fmt.Errorf("release %s is managed by Helm 3, but the client is Helm 2", p.Release)
+	case major == 3 && v2 && !p.HelmMigrate:
+		return fmt.Errorf("release %s is managed by Helm 2, but the client is Helm 3, set helm_migrate to convert it", p.Release)
+	case major == 3 && v2 && p.DryRun:
+		logrus.WithField("release", p.Release).Info("dry run, skipping the conversion of the Helm 2 release")
+	case major == 3 && v2:
+		installed, err := p.helmPluginInstalled("2to3")
+		if err != nil {
+			return err
+		}
+		if !installed {
+			return fmt.Errorf("helm_migrate needs the helm-2to3 plugin, which %s doesn't have", p.helm())
+		}
+		// helm 2to3 convert $RELEASE
+		return p.run(exec.Command(p.helm(), "2to3", "convert", p.Release))
+	}
+	return nil
+}
+
+// helmPluginInstalled reports whether the helm plugin name is installed.
+// helm plugin list
+func (p Plugin) helmPluginInstalled(name string) (bool, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(p.helm(), "plugin", "list")
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// targets is the TARGETS parameter, a JSON list of deployment targets:
+//
+//	[{"cluster": "eu", "zone": "europe-west1-b", "namespace": ["a", "b"]},
+//	 {"name": "us", "project": "other", "cluster": "us", "zone": "us-east1-b",
+//	  "values": ["replicas=3"]}]
+//
+// A target with several namespaces deploys to each of them. The CLUSTERS
+// parameter has the same shape, see useClusters.
+type targets []target
+
+// overlays is the OVERLAYS parameter, a JSON object of named values
+// overlays which targets reference by their overlay or environment name:
+//
+//	{"prod": {"values": ["replicas=3"], "values_files": ["prod.yaml"]}}
+type overlays map[string]overlay
+
+// overlay are values and values files applied on top of the top-level
+// ones.
+type overlay struct {
+	Values      []string `json:"values"`
+	ValuesFiles []string `json:"values_files"`
+}
+
+// target is a cluster and namespace to deploy to. Empty fields default to
+// the top-level parameters. The overlay, which defaults to the environment,
+// is applied on top of the top-level values and values files, the values
+// and values files of the target on top of the overlay.
+type target struct {
+	Name        string     `json:"name"`
+	Project     string     `json:"project"`
+	Cluster     string     `json:"cluster"`
+	Zone        string     `json:"zone"`
+	Region      string     `json:"region"`
+	Namespace   stringList `json:"namespace"`
+	Release     string     `json:"release"`
+	Environment string     `json:"environment"`
+	Overlay     string     `json:"overlay"`
+	HelmVersion string     `json:"helm_version"`
+	Values      []string   `json:"values"`
+	ValuesFiles []string   `json:"values_files"`
+}
+
+// Decode implements envconfig.Decoder.
+func (t *targets) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]target)(t))
+}
+
+// Decode implements envconfig.Decoder.
+func (o *overlays) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*map[string]overlay)(o))
+}
+
+// overlay returns the overlay of t.
+func (p Plugin) overlay(t target) (overlay, error) {
+	if t.Overlay != "" {
+		o, ok := p.Overlays[t.Overlay]
+		if !ok {
+			return overlay{}, fmt.Errorf("unknown overlay: %s", t.Overlay)
+		}
+		return o, nil
+	}
+	return p.Overlays[t.Environment], nil
+}
+
+// useClusters makes Clusters the targets, which are deployed to one after
+// the other.
+func (p *Plugin) useClusters() error {
+	if len(p.Clusters) == 0 {
+		return nil
+	}
+	if len(p.Targets) > 0 {
+		return errors.New("set either targets or clusters, not both")
+	}
+	// preparePlugin may run again on retries
+	p.Targets, p.Clusters = p.Clusters, nil
+	p.TargetParallelism = 1
+	return nil
+}
+
+// projectTargets returns the targets for each of Projects: each target
+// without a project, or a target with the top-level parameters if there
+// are none, for every project.
+func (p Plugin) projectTargets() targets {
+	base := p.Targets
+	if len(base) == 0 {
+		base = targets{{}}
+	}
+	var ts targets
+	for _, project := range p.Projects {
+		for _, t := range base {
+			if t.Project != "" {
+				continue
+			}
+			t.Project = project
+			if t.Name != "" {
+				t.Name = project + "/" + t.Name
+			} else {
+				t.Name = project
+			}
+			ts = append(ts, t)
+		}
+	}
+	for _, t := range p.Targets {
+		if t.Project != "" {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// lookupCluster returns the name and location of the only cluster of the
+// project.
+// gcloud container clusters list --project $PROJECT --format "value(name,location)"
+func (p Plugin) lookupCluster() (name, location string, err error) {
+	var out bytes.Buffer
+	cmd := exec.Command(gcloudBin, "container", "clusters", "list",
+		"--project", p.Project,
+		"--format", "value(name,location)",
+	)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return "", "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 1 {
+		return "", "", fmt.Errorf("project %s has %d clusters, set the cluster", p.Project, len(lines))
+	}
+	fields := strings.Fields(lines[0])
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unexpected cluster list: %s", lines[0])
+	}
+	return fields[0], fields[1], nil
+}
+
+// clusterActions are executed once per target.
+var clusterActions = map[string]bool{
+	deployPkg: true,
+	deletePkg: true,
+	diffPkg:   true,
+
+	helmfilePkg:  true,
+	kustomizePkg: true,
+	smokeTestPkg: true,
+	scalePkg:     true,
+
+	previewDeployPkg:  true,
+	previewDestroyPkg: true,
+	cleanupPkg:        true,
+	driftPkg:          true,
+
+	rollbackPkg: true,
+}
+
+// targetPlugins returns a copy of p for each target and namespace.
+func (p Plugin) targetPlugins() ([]Plugin, error) {
+	var ps []Plugin
+	for _, t := range p.Targets {
+		o, err := p.overlay(t)
+		if err != nil {
+			return nil, err
+		}
+		values := append(append([]string{}, o.Values...), t.Values...)
+		files := append(append([]string{}, o.ValuesFiles...), t.ValuesFiles...)
+		namespaces := []string(t.Namespace)
+		if len(namespaces) == 0 {
+			namespaces = []string{p.Namespace}
+		}
+		for _, ns := range namespaces {
+			c := p
+			c.Targets = nil
+			if t.Project != "" {
+				c.Project = t.Project
+			}
+			if t.Cluster != "" {
+				c.Cluster = t.Cluster
+			}
+			if t.Zone != "" || t.Region != "" {
+				c.Zone, c.Region = t.Zone, t.Region
+			}
+			if t.Release != "" {
+				c.Release = t.Release
+			}
+			if t.HelmVersion != "" {
+				if c.helmPath, err = resolveHelm(t.HelmVersion); err != nil {
+					return nil, err
+				}
+			}
+			if t.Environment != "" {
+				c.Environment = t.Environment
+			}
+			c.Namespace = ns
+			c.Values = append([]string{}, p.Values...)
+			for _, v := range values {
+				c.Values = append(c.Values, expandEnv(v))
+			}
+			c.ValuesFiles = append([]string{}, p.ValuesFiles...)
+			for _, f := range files {
+				c.ValuesFiles = append(c.ValuesFiles, expandEnv(f))
+			}
+			c.target = t.Name
+			if c.target == "" {
+				c.target = c.Cluster
+			}
+			if len(namespaces) > 1 || t.Name == "" {
+				c.target += "/" + ns
+			}
+			ps = append(ps, c)
+		}
+	}
+	return ps, nil
+}
+
+// setupTargets authenticates once and prepares kubectl and helm for each
+// target. Every target gets its own kubeconfig so they can be deployed
+// concurrently.
+func (p Plugin) setupTargets() (ps []Plugin, err error) {
+	s := p.tracer.start("setup", nil)
+	defer func() { p.tracer.finish(s, err) }()
+
+	if p.authenticated() {
+		if err := p.activateServiceAccount(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.needsCluster() {
+		logrus.Info("no cluster actions, skipping cluster credentials")
+		return nil, nil
+	}
+
+	if ps, err = p.targetPlugins(); err != nil {
+		return nil, err
+	}
+	for i := range ps {
+		t := &ps[i]
+		if t.Cluster == "" {
+			if t.Project == "" {
+				return nil, fmt.Errorf("target %s: no cluster", t.target)
+			}
+			var location string
+			if t.Cluster, location, err = t.lookupCluster(); err != nil {
+				return nil, fmt.Errorf("target %s: %s", t.target, err)
+			}
+			t.setLocation(location)
+			logrus.WithFields(logrus.Fields{
+				"target":   t.target,
+				"cluster":  t.Cluster,
+				"location": location,
+			}).Info("found cluster of project")
+		}
+		kubeconfig, err := ioutil.TempFile("", "kubeconfig")
+		if err != nil {
+			return nil, err
+		}
+		kubeconfig.Close()
+		t.env = append(t.env, "KUBECONFIG="+kubeconfig.Name())
+
+		if err := t.getCredentials(); err != nil {
+			return nil, fmt.Errorf("target %s: %s", t.target, err)
+		}
+		if err := t.helmInit(); err != nil {
+			return nil, fmt.Errorf("target %s: %s", t.target, err)
+		}
+	}
+	return ps, nil
+}
+
+// runTargets executes action a on the targets with at most
+// TargetParallelism targets at a time. Without parallelism the targets are
+// executed in the order they are declared in.
+func (p Plugin) runTargets(a string, ps []Plugin) error {
+	parallelism := p.TargetParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+		sem    = make(chan struct{}, parallelism)
+	)
+	run := func(t Plugin) {
+		err := t.runAction(a)
+		log := logrus.WithFields(logrus.Fields{"action": a, "target": t.target})
+		if err != nil {
+			log.WithError(err).Error("target failed")
+			mu.Lock()
+			failed = append(failed, t.target)
+			mu.Unlock()
+			return
+		}
+		log.Info("target succeeded")
+	}
+	for _, t := range ps {
+		t.tracer = p.tracer.fork()
+		if parallelism == 1 {
+			run(t)
+			continue
+		}
+		wg.Add(1)
+		go func(t Plugin) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			run(t)
+		}(t)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("%s failed on %d of %d targets: %s",
+			a, len(failed), len(ps), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the functions available in values file templates. They
+// take their arguments in the order of the sprig functions of the same name,
+// but only this subset is available.
+var templateFuncs = template.FuncMap{
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"env":        os.Getenv,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"trunc": func(n int, s string) string {
+		if len(s) > n {
+			return s[:n]
+		}
+		return s
+	},
+	"quote":  func(s string) string { return fmt.Sprintf("%q", s) },
+	"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"sha256sum": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"slug": func(s string) string { return slugify(s, maxLabelLength) },
+	"regexReplaceAll": func(regex, s, repl string) (string, error) {
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	},
+	"dict": func(pairs ...interface{}) (map[string]interface{}, error) {
+		if len(pairs)%2 != 0 {
+			return nil, fmt.Errorf("dict needs key value pairs, got %d arguments", len(pairs))
+		}
+		dict := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			dict[fmt.Sprint(pairs[i])] = pairs[i+1]
+		}
+		return dict, nil
+	},
+	"list":          func(items ...interface{}) []interface{} { return items },
+	"now":           time.Now,
+	"semverCompare": semverCompare,
+}
+
+// semverCompare reports whether version satisfies constraint, e.g.
+// ">= 1.2, < 2" or "< 1 || >= 2.1". A constraint is a list of comparisons
+// with =, !=, >, >=, < or <= (= by default) that all have to hold, || joins
+// alternatives. Pre-release and build suffixes are ignored.
+func semverCompare(constraint, version string) (bool, error) {
+	v, err := parseSemver(version)
+	if err != nil {
+		return false, err
+	}
+	for _, alternative := range strings.Split(constraint, "||") {
+		terms := strings.Fields(strings.NewReplacer(",", " ").Replace(alternative))
+		if len(terms) == 0 {
+			return false, fmt.Errorf("empty constraint %q", constraint)
+		}
+		holds := true
+		for i := 0; i < len(terms); i++ {
+			ver := strings.TrimLeft(terms[i], "<>=!")
+			op := terms[i][:len(terms[i])-len(ver)]
+			if ver == "" && i+1 < len(terms) {
+				// the operator is separated from the version, e.g. ">= 1.2"
+				i++
+				ver = terms[i]
+			}
+			c, err := parseSemver(ver)
+			if err != nil {
+				return false, fmt.Errorf("constraint %q: %s", constraint, err)
+			}
+			cmp := compareSemver(v, c)
+			switch op {
+			case "", "=", "==":
+				holds = holds && cmp == 0
+			case "!=":
+				holds = holds && cmp != 0
+			case ">":
+				holds = holds && cmp > 0
+			case ">=":
+				holds = holds && cmp >= 0
+			case "<":
+				holds = holds && cmp < 0
+			case "<=":
+				holds = holds && cmp <= 0
+			default:
+				return false, fmt.Errorf("constraint %q: unsupported operator %q", constraint, op)
+			}
+		}
+		if holds {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parseSemver returns the major, minor and patch number of a version such
+// as v1.2.3-rc.1, missing numbers are 0.
+func parseSemver(version string) ([3]int, error) {
+	var v [3]int
+	s := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", version)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// compareSemver returns -1, 0 or 1 when a is lower than, equal to or
+// higher than b.
+func compareSemver(a, b [3]int) int {
+	for i := range a {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// maxLabelLength is the maximum length of a Kubernetes namespace name.
+const maxLabelLength = 63
+
+var reNonSlug = regexp.MustCompile("[^a-z0-9]+")
+
+// slugify lowercases s and replaces runs of characters other than a-z and
+// 0-9 by a dash. Slugs longer than max are shortened and suffixed with a
+// hash of s, so distinct long names stay distinct.
+func slugify(s string, max int) string {
+	slug := strings.Trim(reNonSlug.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(slug) <= max {
+		return slug
+	}
+	sum := sha256.Sum256([]byte(s))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	return strings.TrimRight(slug[:max-len(suffix)-1], "-") + "-" + suffix
+}
+
+// templateContext returns the data available in values file templates.
+func (p Plugin) templateContext() map[string]interface{} {
+	return map[string]interface{}{
+		"Build":        p.Build,
+		"Release":      p.Release,
+		"Namespace":    p.Namespace,
+		"Environment":  p.Environment,
+		"Project":      p.Project,
+		"Cluster":      p.Cluster,
+		"Zone":         p.Zone,
+		"Region":       p.Region,
+		"Package":      p.Package,
+		"ChartVersion": p.ChartVersion,
+		"BranchSlug":   slugify(p.Build.Branch, maxLabelLength),
+	}
+}
+
+// renderNamespace renders NamespaceTemplate and returns it as a valid
+// namespace name.
+func (p Plugin) renderNamespace() (string, error) {
+	return p.renderName("namespace", p.NamespaceTemplate, maxLabelLength)
+}
+
+// renderName renders the template tmpl of a name and returns it as slug of
+// at most max characters.
+func (p Plugin) renderName(what, tmpl string, max int) (string, error) {
+	t, err := template.New(what).Funcs(templateFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, p.templateContext()); err != nil {
+		return "", err
+	}
+	name := slugify(out.String(), max)
+	if name == "" {
+		return "", fmt.Errorf("%s template %q renders empty", what, tmpl)
+	}
+	return name, nil
+}
+
+// renderValuesFile renders the values file name through text/template and
+// writes the result to a temporary file, whose name is returned.
+func (p Plugin) renderValuesFile(name string, data []byte) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, p.templateContext()); err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", "values")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(out.Bytes()); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
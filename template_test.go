@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{`{{ regexReplaceAll "[^a-z]+" "feature/Login-42" "-" }}`, "feature-ogin-"},
+		{`{{ $d := dict "replicas" 2 "tier" "web" }}{{ $d.tier }}/{{ $d.replicas }}`, "web/2"},
+		{`{{ range list "a" "b" }}{{ . }}{{ end }}`, "ab"},
+		{`{{ gt (now).Year 2000 }}`, "true"},
+		{`{{ semverCompare ">= 1.2, < 2" "v1.10.0" }}`, "true"},
+		{`{{ semverCompare ">=1.2 <2" "2.0.0-rc.1" }}`, "false"},
+		{`{{ semverCompare "< 1 || >= 2.1" "2.1" }}`, "true"},
+		{`{{ semverCompare "!= 1.2.3" "1.2.3+build.7" }}`, "false"},
+	}
+	for _, tt := range tests {
+		tmpl, err := template.New("test").Funcs(templateFuncs).Parse(tt.tmpl)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.tmpl, err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, nil); err != nil {
+			t.Fatalf("%s: %s", tt.tmpl, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s = %q, want %q", tt.tmpl, out.String(), tt.want)
+		}
+	}
+}
+
+func TestSemverCompareErrors(t *testing.T) {
+	for _, constraint := range []string{"", "~1.2", ">= x.y"} {
+		if _, err := semverCompare(constraint, "1.2.3"); err == nil {
+			t.Errorf("semverCompare(%q) succeeded, want an error", constraint)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ensureNamespace creates the namespace unless it exists.
+// kubectl create namespace $PLUGIN_NAMESPACE
+func (p Plugin) ensureNamespace() error {
+	if err := p.run(exec.Command(p.kubectl(), "get", "namespace", p.Namespace)); err == nil {
+		return nil
+	}
+	return p.run(exec.Command(p.kubectl(), "create", "namespace", p.Namespace))
+}
+
+// deployTillerless deploys the release without Tiller: the chart is
+// rendered with helm template and applied with kubectl, pruning the
+// objects of the release, labeled release=$RELEASE, the chart no longer
+// renders.
+// helm template $PACKAGE-$PLUGIN_CHART_VERSION.tgz | kubectl apply --prune -l release=$RELEASE -f -
+func (p Plugin) deployTillerless() error {
+	manifests, err := p.renderManifests()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(p.kubectl(), "apply",
+		"--namespace", p.Namespace,
+		"--prune", "-l", "release="+p.Release,
+		"-f", "-",
+	)
+	if p.DryRun {
+		p.printCommand(cmd)
+		return nil
+	}
+	if err := p.ensureNamespace(); err != nil {
+		return err
+	}
+	cmd.Stdin = strings.NewReader(manifests)
+	if err := p.run(cmd); err != nil {
+		return err
+	}
+	logrus.WithField("release", p.Release).Info("applied rendered release")
+	if p.Wait || p.RolloutStatus {
+		return p.waitForRollout(manifests)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	helmDownloadURL    = "https://get.helm.sh/helm-%s-linux-amd64.tar.gz"
+	kubectlDownloadURL = "https://dl.k8s.io/release/%s/bin/linux/amd64/kubectl"
+)
+
+// toolsDir returns the directory downloaded tools are kept in.
+func (p Plugin) toolsDir() string {
+	if p.CacheDir != "" {
+		return filepath.Join(p.CacheDir, "tools")
+	}
+	return filepath.Join(os.TempDir(), "drone-gcloud-helm-tools")
+}
+
+// downloadHelm returns the path of the helm client of version, downloading
+// it unless it is cached.
+func (p Plugin) downloadHelm(version string) (string, error) {
+	version = "v" + strings.TrimPrefix(version, "v")
+	url := fmt.Sprintf(helmDownloadURL, version)
+	return p.downloadTool(filepath.Join("helm", version, "helm"), url, func(data []byte) ([]byte, error) {
+		return untarFile(data, "linux-amd64/helm")
+	})
+}
+
+// downloadKubectl returns the path of kubectl of version, downloading it
+// unless it is cached.
+func (p Plugin) downloadKubectl(version string) (string, error) {
+	version = "v" + strings.TrimPrefix(version, "v")
+	url := fmt.Sprintf(kubectlDownloadURL, version)
+	return p.downloadTool(filepath.Join("kubectl", version, "kubectl"), url, nil)
+}
+
+// downloadTool downloads url, verifies it against the SHA-256 checksum
+// published next to it and stores the binary, extracted by extract if not
+// nil, as name in the tools directory. A stored binary is reused.
+func (p Plugin) downloadTool(name, url string, extract func([]byte) ([]byte, error)) (string, error) {
+	path := filepath.Join(p.toolsDir(), name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	logrus.WithField("url", url).Info("downloading tool")
+	data, err := download(url)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyChecksum(url, data); err != nil {
+		return "", err
+	}
+	if extract != nil {
+		if data, err = extract(data); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	// write to a temporary file first, so a partial binary is never used
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0755); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// verifyChecksum checks data against the checksum file of url. Helm
+// publishes .sha256sum files, kubectl .sha256 files.
+func verifyChecksum(url string, data []byte) error {
+	var sum []byte
+	var err error
+	for _, ext := range []string{".sha256sum", ".sha256"} {
+		if sum, err = download(url + ext); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("no checksum for %s: %s", url, err)
+	}
+	fields := strings.Fields(string(sum))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum for %s", url)
+	}
+	actual := sha256.Sum256(data)
+	if hex.EncodeToString(actual[:]) != strings.ToLower(fields[0]) {
+		return fmt.Errorf("checksum mismatch for %s", url)
+	}
+	return nil
+}
+
+// download returns the body of url.
+func download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// untarFile returns the file name of the gzipped tar archive data.
+func untarFile(data []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in archive", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Name == name {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLP span kind and status codes, see opentelemetry-proto trace.proto
+const (
+	otlpKindInternal = 1
+	otlpStatusOk     = 1
+	otlpStatusError  = 2
+)
+
+// span is a single timed operation of the plugin execution.
+type span struct {
+	name     string
+	spanID   string
+	parent   *span
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	errorMsg string
+	failed   bool
+}
+
+// tracer records spans of one plugin run and exports them via OTLP/HTTP
+// using the JSON encoding.
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	traceID  string
+	current  *span
+	store    *spanStore
+}
+
+// spanStore holds the spans of all forks of a tracer.
+type spanStore struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+// newTracer creates a tracer exporting to an OTLP collector at endpoint.
+// headers is a comma separated list of key=value pairs.
+func newTracer(endpoint, headers string) *tracer {
+	t := &tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:  make(map[string]string),
+		traceID:  randomHex(16),
+		store:    &spanStore{},
+	}
+	for _, h := range strings.Split(headers, ",") {
+		kv := strings.SplitN(h, "=", 2)
+		if len(kv) == 2 {
+			t.headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return t
+}
+
+// start opens a child span of the current span. It is safe to call on a
+// nil tracer.
+func (t *tracer) start(name string, attrs map[string]string) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{
+		name:   name,
+		spanID: randomHex(8),
+		parent: t.current,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	t.current = s
+	t.store.mu.Lock()
+	t.store.spans = append(t.store.spans, s)
+	t.store.mu.Unlock()
+	return s
+}
+
+// fork returns a tracer sharing the spans of t whose spans are children of
+// the current span of t. Forks can be used concurrently.
+func (t *tracer) fork() *tracer {
+	if t == nil {
+		return nil
+	}
+	f := *t
+	return &f
+}
+
+// finish closes s and makes its parent the current span again.
+func (t *tracer) finish(s *span, err error) {
+	if t == nil || s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.failed = true
+		s.errorMsg = err.Error()
+	}
+	t.current = s.parent
+}
+
+// export sends all finished spans to the collector.
+func (t *tracer) export() error {
+	if t == nil {
+		return nil
+	}
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	if len(t.store.spans) == 0 {
+		return nil
+	}
+
+	spans := make([]map[string]interface{}, 0, len(t.store.spans))
+	for _, s := range t.store.spans {
+		if s.end.IsZero() {
+			continue
+		}
+		status := map[string]interface{}{"code": otlpStatusOk}
+		if s.failed {
+			status = map[string]interface{}{"code": otlpStatusError, "message": s.errorMsg}
+		}
+		entry := map[string]interface{}{
+			"traceId":           t.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              otlpKindInternal,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            status,
+		}
+		if s.parent != nil {
+			entry["parentSpanId"] = s.parent.spanID
+		}
+		spans = append(spans, entry)
+	}
+
+	return postJSON(t.endpoint, t.headers, map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": "drone-gcloud-helm"}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "drone-gcloud-helm"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+}
+
+// otlpAttributes converts attrs to OTLP key values.
+func otlpAttributes(attrs map[string]string) []interface{} {
+	result := make([]interface{}, 0, len(attrs))
+	for k, v := range attrs {
+		result = append(result, map[string]interface{}{
+			"key":   k,
+			"value": map[string]string{"stringValue": v},
+		})
+	}
+	return result
+}
+
+// commandName returns a short name for the command args such as
+// "helm upgrade", unwrapping commands executed via /bin/sh -c.
+func commandName(args []string) string {
+	if len(args) == 3 && args[0] == "/bin/sh" && args[1] == "-c" {
+		args = strings.Fields(args[2])
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	name := filepath.Base(args[0])
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		name += " " + args[1]
+	}
+	return name
+}
+
+// randomHex returns n random bytes hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
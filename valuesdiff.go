@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// deployedValues returns the user-supplied values of the deployed release
+// flattened to dotted keys.
+// helm get values $RELEASE
+func (p Plugin) deployedValues() (map[string]string, error) {
+	namespace, err := p.helmNamespaceArgs()
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(p.helm(), append([]string{"get", "values", p.Release}, namespace...)...)
+	cmd.Stdout = &out
+	if err := p.run(cmd); err != nil {
+		return nil, err
+	}
+	// Helm 3 prints a header before the values
+	data := strings.TrimPrefix(out.String(), "USER-SUPPLIED VALUES:\n")
+	var values map[interface{}]interface{}
+	if err := yaml.Unmarshal([]byte(data), &values); err != nil {
+		return nil, err
+	}
+	flat := make(map[string]string)
+	flattenValues("", values, flat)
+	return flat, nil
+}
+
+// newValues returns the values files and --set values of the deploy
+// flattened to dotted keys. Secret values are left out.
+func (p Plugin) newValues(files []string) (map[string]string, error) {
+	flat := make(map[string]string)
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var values map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("%s: %s", f, err)
+		}
+		file := make(map[string]string)
+		flattenValues("", values, file)
+		for k, v := range file {
+			setValue(flat, k, v)
+		}
+	}
+	set := append(p.plainValues(), "namespace="+p.Namespace)
+	if p.imageTagArgs() != "" {
+		set = append(set, p.ImageTagValue+"="+p.imageTag())
+	}
+	set = append(set, p.droneMetadataValues()...)
+	for _, v := range set {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 {
+			setValue(flat, kv[0], kv[1])
+		}
+	}
+	return flat, nil
+}
+
+// setValue sets key to value in flat, replacing the values of its parents
+// and children like a later values file does.
+func setValue(flat map[string]string, key, value string) {
+	for k := range flat {
+		if isValuePrefix(key, k) || isValuePrefix(k, key) {
+			delete(flat, k)
+		}
+	}
+	flat[key] = value
+}
+
+// isValuePrefix reports whether key is a parent of the flattened key k.
+func isValuePrefix(key, k string) bool {
+	return strings.HasPrefix(k, key+".") || strings.HasPrefix(k, key+"[")
+}
+
+// flattenValues adds the values to flat with their keys joined by dots,
+// list items are keyed by their index like --set does.
+func flattenValues(prefix string, value interface{}, flat map[string]string) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		if len(v) == 0 && prefix != "" {
+			flat[prefix] = "{}"
+		}
+		for k, item := range v {
+			key := fmt.Sprint(k)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenValues(key, item, flat)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			flat[prefix] = "[]"
+		}
+		for i, item := range v {
+			flattenValues(fmt.Sprintf("%s[%d]", prefix, i), item, flat)
+		}
+	default:
+		if prefix != "" {
+			flat[prefix] = fmt.Sprint(v)
+		}
+	}
+}
+
+// printValuesDiff prints the keys of the user-supplied values which the
+// deploy adds (+), removes (-) or changes (~) compared to the deployed
+// release, so configuration changes can be told from template changes.
+// Only the keys are printed, values may be secret.
+func (p Plugin) printValuesDiff(files []string) error {
+	deployed, err := p.deployedValues()
+	if err != nil {
+		logrus.WithError(err).Info("no deployed values to compare, first deploy?")
+		return nil
+	}
+	values, err := p.newValues(files)
+	if err != nil {
+		return err
+	}
+	secret := make(map[string]bool)
+	for _, v := range p.Values {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 && isSecretRef(kv[1]) {
+			secret[kv[0]] = true
+		}
+	}
+	for _, entry := range append(append([]string{}, p.SecretValues...), envSecretValues()...) {
+		secret[strings.SplitN(entry, "=", 2)[0]] = true
+	}
+
+	var lines []string
+	for k, v := range values {
+		old, ok := deployed[k]
+		switch {
+		case secret[k]:
+		case !ok:
+			lines = append(lines, "+ "+k)
+		case old != v:
+			lines = append(lines, "~ "+k)
+		}
+	}
+	for k := range deployed {
+		if _, ok := values[k]; !ok && !secret[k] {
+			lines = append(lines, "- "+k)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+
+	logrus.WithFields(logrus.Fields{
+		"release": p.Release,
+		"changes": len(lines),
+	}).Info("values diff against the deployed release")
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	return nil
+}
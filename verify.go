@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// verifyPollInterval is the interval in which failed checks are retried.
+	verifyPollInterval = 5 * time.Second
+
+	// portForwardTimeout limits the wait for the forwarded port.
+	portForwardTimeout = 30 * time.Second
+)
+
+// httpCheck is a check of VerifyChecks, given as path or path=status.
+type httpCheck struct {
+	path   string
+	status int
+}
+
+// parseCheck parses a check of VerifyChecks. Without status any 2xx status
+// passes.
+func parseCheck(s string) httpCheck {
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		if status, err := strconv.Atoi(s[i+1:]); err == nil {
+			return httpCheck{path: s[:i], status: status}
+		}
+	}
+	return httpCheck{path: s}
+}
+
+// passes reports whether status is the expected status of the check.
+func (c httpCheck) passes(status int) bool {
+	if c.status == 0 {
+		return status >= 200 && status < 300
+	}
+	return status == c.status
+}
+
+// verifyRelease forwards a local port to VerifyPort of VerifyService and
+// requests the paths of VerifyChecks until they pass or WaitTimeout
+// expired. Services need not be reachable from the runner this way.
+// kubectl port-forward svc/$PLUGIN_VERIFY_SERVICE PORT:$PLUGIN_VERIFY_PORT --namespace $PLUGIN_NAMESPACE
+func (p Plugin) verifyRelease() error {
+	service := p.VerifyService
+	if service == "" {
+		service = p.Release
+	}
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+	stopHeartbeat := p.heartbeat("the verification of service " + service)
+	defer stopHeartbeat()
+
+	// runs in the background until the checks are done, which kill it
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	cmd := exec.CommandContext(ctx, p.kubectl(), "port-forward",
+		"svc/"+service,
+		fmt.Sprintf("%d:%d", port, p.VerifyPort),
+		"--namespace", p.Namespace,
+	)
+	exited := make(chan error, 1)
+	go func() { exited <- p.run(cmd) }()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	if err := waitForPort(addr, exited); err != nil {
+		return fmt.Errorf("port-forward to %s: %s", service, err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	deadline := time.Now().Add(time.Duration(p.WaitTimeout) * time.Second)
+	for _, s := range p.VerifyChecks {
+		c := parseCheck(s)
+		url := "http://" + addr + "/" + strings.TrimPrefix(c.path, "/")
+		log := logrus.WithFields(logrus.Fields{"service": service, "path": c.path})
+		for {
+			status, err := httpStatus(client, url)
+			if err == nil && c.passes(status) {
+				log.WithField("status", status).Info("check passed")
+				break
+			}
+			if err == nil {
+				err = fmt.Errorf("unexpected status %d", status)
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("check %s of %s failed: %s", c.path, service, err)
+			}
+			log.WithError(err).Warn("check failed, retrying")
+			time.Sleep(verifyPollInterval)
+		}
+	}
+	return nil
+}
+
+// freePort returns a free local TCP port.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitForPort waits until addr accepts connections. It fails when exited
+// receives the exit of the forwarding process.
+func waitForPort(addr string, exited <-chan error) error {
+	deadline := time.Now().Add(portForwardTimeout)
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return err
+		default:
+		}
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// httpStatus requests url and returns the response status.
+func httpStatus(client *http.Client, url string) (int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// version strategies, see VersionStrategy
+const (
+	versionTag         = "tag"
+	versionBuildNumber = "build-number"
+	versionCommitSHA   = "commit-sha"
+	versionChartYAML   = "chart-yaml"
+)
+
+// chartMetadata is the part of Chart.yaml the plugin reads.
+type chartMetadata struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+// readChartMetadata reads the Chart.yaml of the chart in dir.
+func readChartMetadata(dir string) (chartMetadata, error) {
+	var meta chartMetadata
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return meta, err
+	}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("%s: %s", filepath.Join(dir, "Chart.yaml"), err)
+	}
+	return meta, nil
+}
+
+// resolveChartVersion sets ChartVersion, unless given, following
+// VersionStrategy and returns the source of the version. Without a
+// strategy the tag is used, then the build number.
+func (p *Plugin) resolveChartVersion() (string, error) {
+	switch {
+	case p.ChartVersion != "":
+		return "chart_version", nil
+	case p.RemoteChart != "":
+		// the latest version of the remote repository
+		return "latest", nil
+	}
+
+	switch p.VersionStrategy {
+	case "":
+		switch {
+		case p.Build.Tag != "":
+			return p.resolveChartVersionWith(versionTag)
+		case p.Build.Number != "":
+			return p.resolveChartVersionWith(versionBuildNumber)
+		}
+		return "none", nil
+	case versionTag, versionBuildNumber, versionCommitSHA, versionChartYAML:
+		return p.resolveChartVersionWith(p.VersionStrategy)
+	}
+	return "", fmt.Errorf("unknown version_strategy %q, use %s, %s, %s or %s",
+		p.VersionStrategy, versionTag, versionBuildNumber, versionCommitSHA, versionChartYAML)
+}
+
+// resolveChartVersionWith sets ChartVersion following strategy.
+func (p *Plugin) resolveChartVersionWith(strategy string) (string, error) {
+	switch strategy {
+	case versionTag:
+		if p.Build.Tag == "" {
+			return "", errors.New("version_strategy tag needs a tag build")
+		}
+		p.ChartVersion = strings.TrimPrefix(p.Build.Tag, "v")
+		return "tag", nil
+	case versionBuildNumber:
+		if p.Build.Number == "" {
+			return "", errors.New("version_strategy build-number needs a build number")
+		}
+		p.ChartVersion = "0.0." + p.Build.Number
+		if p.Build.Commit != "" {
+			p.ChartVersion += "+" + p.Build.shortCommit()
+		}
+		return "build number", nil
+	case versionCommitSHA:
+		if p.Build.Commit == "" {
+			return "", errors.New("version_strategy commit-sha needs a commit")
+		}
+		// the g prefix keeps the pre-release valid semver for all digit shas
+		p.ChartVersion = "0.0.0-g" + p.Build.shortCommit()
+		return "commit", nil
+	case versionChartYAML:
+		if p.ChartPath == "" && len(p.Charts) > 0 {
+			// each chart has its own, see resolveChartVersions
+			return "Chart.yaml of each chart", nil
+		}
+		meta, err := readChartMetadata(p.ChartPath)
+		if err != nil {
+			return "", err
+		}
+		if meta.Version == "" {
+			return "", fmt.Errorf("%s/Chart.yaml has no version", p.ChartPath)
+		}
+		p.ChartVersion = meta.Version
+		return "Chart.yaml", nil
+	}
+	return "", fmt.Errorf("unknown version_strategy %q", strategy)
+}
+
+// resolveChartVersions sets the version of the Charts without one to the
+// version of their Chart.yaml when VersionStrategy is chart-yaml.
+func (p *Plugin) resolveChartVersions() error {
+	if p.VersionStrategy != versionChartYAML || p.ChartVersion != "" {
+		return nil
+	}
+	for i := range p.Charts {
+		ch := &p.Charts[i]
+		if ch.ChartVersion != "" {
+			continue
+		}
+		meta, err := readChartMetadata(ch.ChartPath)
+		if err != nil {
+			return err
+		}
+		if meta.Version == "" {
+			return fmt.Errorf("%s/Chart.yaml has no version", ch.ChartPath)
+		}
+		ch.ChartVersion = meta.Version
+	}
+	return nil
+}